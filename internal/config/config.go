@@ -0,0 +1,360 @@
+// Package config layers the settings of an example from lowest to highest precedence: built-in defaults, a YAML,
+// TOML or JSON config file, INQUIRY_* environment variables and finally command line flags.
+//
+// Settings are the fields of a struct tagged `config:"<name>"`, nested structs being sections and string maps
+// being properties handed on as is. Fields tagged `secret:"true"` are redacted whenever the configuration is
+// printed.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// EnvPrefix is prepended to every environment variable overriding the configuration
+	EnvPrefix = "INQUIRY_"
+	// RedactedValue replaces secrets whenever the configuration is printed
+	RedactedValue = "******"
+)
+
+// Load parses the flags and layers the config file and environment underneath them into the struct c points
+// to. file is the variable of the -config flag, falling back to INQUIRY_CONFIG.
+func Load(c interface{}, fs *flag.FlagSet, args []string, file *string) error {
+	// The first pass only finds out about -config
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *file == "" {
+		*file = os.Getenv(EnvPrefix + "CONFIG")
+	}
+	if *file != "" {
+		if err := LoadFile(c, *file); err != nil {
+			return err
+		}
+	}
+	if err := LoadEnv(c, os.Environ()); err != nil {
+		return err
+	}
+
+	// Parse again so that flags given explicitly win over the file and environment
+	return fs.Parse(args)
+}
+
+// LoadFile applies a config file, its format told by the extension.
+func LoadFile(c interface{}, path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var raw map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &raw)
+	case ".toml":
+		_, err = toml.Decode(string(b), &raw)
+	case ".json":
+		// Numbers stay as written, as float64 large ints would print in exponent form and fail to parse
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		err = dec.Decode(&raw)
+	default:
+		return fmt.Errorf("unsupported config file format %q, use .yaml, .toml or .json", path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	if err := SetFromMap(reflect.ValueOf(c).Elem(), "", raw); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// LoadEnv applies INQUIRY_<SECTION>_<KEY> variables, e.g. INQUIRY_REDIS_HTTP_POOL_SIZE=10.
+// Map entries take the rest of the name as key, e.g. INQUIRY_KAFKA_PROPERTIES_LINGER_MS=5 sets linger.ms.
+func LoadEnv(c interface{}, environ []string) error {
+	env := map[string]string{}
+	for _, kv := range environ {
+		if i := strings.Index(kv, "="); i > 0 && strings.HasPrefix(kv, EnvPrefix) {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	fields := fieldsOf(reflect.ValueOf(c).Elem(), "")
+	// Names of plain settings aren't map entries even when they extend a map's name, e.g. INQUIRY_KAFKA_CONSUMER_GROUP
+	// is kafka.consumerGroup and not the group property of kafka.consumer
+	declared := map[string]bool{}
+	for _, f := range fields {
+		if f.value.Kind() != reflect.Map {
+			declared[EnvName(f.path)] = true
+		}
+	}
+
+	for _, f := range fields {
+		name := EnvName(f.path)
+
+		if f.value.Kind() == reflect.Map {
+			for k, v := range env {
+				if strings.HasPrefix(k, name+"_") && !declared[k] {
+					key := strings.Replace(strings.ToLower(k[len(name)+1:]), "_", ".", -1)
+					f.value.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(v))
+				}
+			}
+			continue
+		}
+
+		if v, ok := env[name]; ok {
+			if err := setValue(f.value, v); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Dump writes the configuration c points to in the given format with secrets redacted.
+func Dump(c interface{}, w io.Writer, format string) error {
+	tree := Tree(reflect.ValueOf(c).Elem())
+
+	switch format {
+	case "yaml":
+		b, err := yaml.Marshal(tree)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	case "toml":
+		return toml.NewEncoder(w).Encode(tree)
+	case "json":
+		b, err := json.MarshalIndent(tree, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(b, '\n'))
+		return err
+	default:
+		return fmt.Errorf("unsupported format %q, use yaml, toml or json", format)
+	}
+}
+
+type field struct {
+	path  string
+	value reflect.Value
+}
+
+// fieldsOf flattens a config struct into its leaves keyed by dotted path, e.g. redis.http.poolSize.
+func fieldsOf(v reflect.Value, prefix string) []field {
+	var fields []field
+	for i := 0; i < v.NumField(); i++ {
+		path := prefix + v.Type().Field(i).Tag.Get("config")
+		if v.Field(i).Kind() == reflect.Struct {
+			fields = append(fields, fieldsOf(v.Field(i), path+".")...)
+			continue
+		}
+		fields = append(fields, field{path: path, value: v.Field(i)})
+	}
+	return fields
+}
+
+// SetFromMap applies a decoded section to the config struct v, prefix being the section's path in errors.
+func SetFromMap(v reflect.Value, prefix string, raw map[string]interface{}) error {
+	fields := map[string]reflect.Value{}
+	for i := 0; i < v.NumField(); i++ {
+		fields[v.Type().Field(i).Tag.Get("config")] = v.Field(i)
+	}
+
+	for key, rawValue := range raw {
+		field, ok := fields[key]
+		if !ok {
+			return fmt.Errorf("unknown setting %s%s", prefix, key)
+		}
+
+		switch field.Kind() {
+		case reflect.Struct, reflect.Map:
+			section, err := toStringMap(rawValue)
+			if err != nil {
+				return fmt.Errorf("%s%s: %v", prefix, key, err)
+			}
+			if field.Kind() == reflect.Map {
+				for k, v := range section {
+					field.SetMapIndex(reflect.ValueOf(k), reflect.ValueOf(fmt.Sprint(v)))
+				}
+				continue
+			}
+			if err := SetFromMap(field, prefix+key+".", section); err != nil {
+				return err
+			}
+		default:
+			if err := setValue(field, rawValue); err != nil {
+				return fmt.Errorf("%s%s: %v", prefix, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+func setValue(field reflect.Value, raw interface{}) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		s, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("expected a duration such as \"10s\", got %v", raw)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	s := fmt.Sprint(raw)
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+func toStringMap(raw interface{}) (map[string]interface{}, error) {
+	switch m := raw.(type) {
+	case map[string]interface{}:
+		return m, nil
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			out[fmt.Sprint(k)] = v
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("expected a section, got %v", raw)
+	}
+}
+
+// Tree renders a config struct as nested maps, with durations as strings and secrets redacted.
+func Tree(v reflect.Value) map[string]interface{} {
+	tree := map[string]interface{}{}
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		name := sf.Tag.Get("config")
+		field := v.Field(i)
+
+		switch {
+		case field.Kind() == reflect.Struct:
+			tree[name] = Tree(field)
+		case sf.Tag.Get("secret") == "true" && field.Kind() == reflect.Map:
+			redacted := map[string]interface{}{}
+			for _, k := range field.MapKeys() {
+				redacted[k.String()] = RedactedValue
+			}
+			tree[name] = redacted
+		case sf.Tag.Get("secret") == "true" && field.String() != "":
+			tree[name] = RedactedValue
+		case field.Type() == reflect.TypeOf(time.Duration(0)):
+			tree[name] = time.Duration(field.Int()).String()
+		case field.Kind() == reflect.Map:
+			tree[name] = RedactProperties(field.Interface().(map[string]string))
+		default:
+			tree[name] = field.Interface()
+		}
+	}
+	return tree
+}
+
+// EnvName turns a dotted path such as redis.http.poolSize into INQUIRY_REDIS_HTTP_POOL_SIZE.
+func EnvName(path string) string {
+	var parts []string
+	for _, part := range strings.Split(path, ".") {
+		var b strings.Builder
+		for i, r := range part {
+			if i > 0 && unicode.IsUpper(r) && !unicode.IsUpper(rune(part[i-1])) {
+				b.WriteRune('_')
+			}
+			b.WriteRune(unicode.ToUpper(r))
+		}
+		parts = append(parts, b.String())
+	}
+	return EnvPrefix + strings.Join(parts, "_")
+}
+
+// Properties collects repeated -X key=value flags into a property map.
+type Properties map[string]string
+
+func (p Properties) String() string {
+	var kvs []string
+	for k, v := range RedactProperties(p) {
+		kvs = append(kvs, fmt.Sprintf("%s=%v", k, v))
+	}
+	sort.Strings(kvs)
+	return strings.Join(kvs, ",")
+}
+
+func (p Properties) Set(kv string) error {
+	i := strings.Index(kv, "=")
+	if i <= 0 {
+		return fmt.Errorf("expected key=value, got %q", kv)
+	}
+	p[strings.TrimSpace(kv[:i])] = kv[i+1:]
+	return nil
+}
+
+// IsSecretProperty tells whether a librdkafka property holds credentials.
+func IsSecretProperty(key string) bool {
+	key = strings.ToLower(key)
+	return strings.Contains(key, "password") ||
+		strings.Contains(key, "secret") ||
+		strings.Contains(key, "jaas") ||
+		key == "ssl.key.pem" ||
+		key == "sasl.oauthbearer.config"
+}
+
+// RedactProperties copies props with the values of secret properties replaced.
+func RedactProperties(props map[string]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(props))
+	for k, v := range props {
+		if IsSecretProperty(k) {
+			out[k] = RedactedValue
+		} else {
+			out[k] = v
+		}
+	}
+	return out
+}
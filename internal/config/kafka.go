@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	log "github.com/sirupsen/logrus"
+)
+
+// KafkaConfigMap layers the common and then the role specific librdkafka passthrough properties on top of base,
+// logging the effective configuration with secrets redacted.
+func KafkaConfigMap(role string, common, roleProps map[string]string, base kafka.ConfigMap) *kafka.ConfigMap {
+	conf := kafka.ConfigMap{}
+	for k, v := range base {
+		conf[k] = v
+	}
+	for _, props := range []map[string]string{common, roleProps} {
+		for k, v := range props {
+			conf[k] = propertyValue(k, v)
+		}
+	}
+
+	effective := map[string]string{}
+	for k, v := range conf {
+		effective[k] = fmt.Sprint(v)
+	}
+	log.WithFields(RedactProperties(effective)).WithField("role", role).Info("Effective kafka configuration")
+	return &conf
+}
+
+// propertyValue types the Go client's own go.* properties, which aren't accepted as strings.
+func propertyValue(key, value string) kafka.ConfigValue {
+	if !strings.HasPrefix(key, "go.") {
+		return value
+	}
+	if b, err := strconv.ParseBool(value); err == nil {
+		return b
+	}
+	if n, err := strconv.Atoi(value); err == nil {
+		return n
+	}
+	return value
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func TestKafkaConfigMap(t *testing.T) {
+	conf := KafkaConfigMap("producer",
+		map[string]string{"linger.ms": "5", "acks": "1", "go.delivery.reports": "false"},
+		map[string]string{"acks": "all", "sasl.password": "s3cret"},
+		kafka.ConfigMap{"bootstrap.servers": "localhost", "linger.ms": "0"})
+
+	want := kafka.ConfigMap{
+		"bootstrap.servers":   "localhost",
+		"linger.ms":           "5",
+		"acks":                "all",
+		"go.delivery.reports": false,
+		"sasl.password":       "s3cret",
+	}
+	if len(*conf) != len(want) {
+		t.Fatalf("got %v, want %v", *conf, want)
+	}
+	for k, v := range want {
+		if (*conf)[k] != v {
+			t.Errorf("%s = %v (%T), want %v (%T)", k, (*conf)[k], (*conf)[k], v, v)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+// Package kafkatest is an in-memory kafka standing in for librdkafka clients in tests.
+package kafkatest
+
+import (
+	"errors"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+var (
+	ErrClosed  = errors.New("fake kafka: client is closed")
+	ErrTimeout = errors.New("fake kafka: timed out")
+)
+
+// Broker is an in-memory kafka. Topics are logs of partitions with offsets, created with the default
+// number of partitions on first use. Consumer groups share the partitions of their topics between members
+// and commit offsets as messages are read, like librdkafka's auto commit of stored offsets.
+type Broker struct {
+	partitions int32
+
+	mu sync.Mutex
+	// changed is closed, and replaced, whenever messages arrive, groups rebalance or a client closes
+	changed    chan struct{}
+	topics     map[string][][]*kafka.Message
+	groups     map[string]*consumerGroup
+	next       int32
+	produceErr error
+}
+
+type consumerGroup struct {
+	members   []*Consumer
+	committed map[topicPartition]int64
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+func NewBroker(partitions int32) *Broker {
+	return &Broker{
+		partitions: partitions,
+		changed:    make(chan struct{}),
+		topics:     map[string][][]*kafka.Message{},
+		groups:     map[string]*consumerGroup{},
+	}
+}
+
+// FailProduce makes deliveries report err, until called again with nil.
+func (b *Broker) FailProduce(err error) {
+	b.mu.Lock()
+	b.produceErr = err
+	b.mu.Unlock()
+}
+
+// Messages returns a copy of what's been produced to the partition.
+func (b *Broker) Messages(topic string, partition int32) []*kafka.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*kafka.Message(nil), b.topicLocked(topic)[partition]...)
+}
+
+func (b *Broker) notifyLocked() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *Broker) topicLocked(topic string) [][]*kafka.Message {
+	log, ok := b.topics[topic]
+	if !ok {
+		log = make([][]*kafka.Message, b.partitions)
+		b.topics[topic] = log
+	}
+	return log
+}
+
+func (b *Broker) metadata(topic *string) (*kafka.Metadata, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	md := &kafka.Metadata{
+		Brokers: []kafka.BrokerMetadata{{ID: 1, Host: "fake", Port: 9092}},
+		Topics:  map[string]kafka.TopicMetadata{},
+	}
+	for name := range b.topics {
+		if topic != nil && *topic != name {
+			continue
+		}
+		tm := kafka.TopicMetadata{Topic: name}
+		for p := int32(0); p < b.partitions; p++ {
+			tm.Partitions = append(tm.Partitions, kafka.PartitionMetadata{ID: p, Leader: 1, Replicas: []int32{1}, Isrs: []int32{1}})
+		}
+		md.Topics[name] = tm
+	}
+	return md, nil
+}
+
+// rebalanceLocked spreads the partitions of the topics the members subscribed to between them, round robin in
+// the order they joined. Members resume from the committed offsets, else from where their reset policy says.
+func (b *Broker) rebalanceLocked(group *consumerGroup) {
+	topics := map[string]bool{}
+	for _, m := range group.members {
+		for _, t := range m.topics {
+			topics[t] = true
+		}
+	}
+	var all []topicPartition
+	for t := range topics {
+		b.topicLocked(t)
+		for p := int32(0); p < b.partitions; p++ {
+			all = append(all, topicPartition{t, p})
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].topic != all[j].topic {
+			return all[i].topic < all[j].topic
+		}
+		return all[i].partition < all[j].partition
+	})
+
+	for _, m := range group.members {
+		m.assignment = nil
+		m.positions = map[topicPartition]int64{}
+	}
+	if len(group.members) == 0 {
+		return
+	}
+	for i, fp := range all {
+		m := group.members[i%len(group.members)]
+		m.assignment = append(m.assignment, fp)
+		pos, ok := group.committed[fp]
+		if !ok {
+			pos = 0
+			if m.reset == kafka.OffsetEnd {
+				pos = int64(len(b.topics[fp.topic][fp.partition]))
+			}
+		}
+		m.positions[fp] = pos
+	}
+	b.notifyLocked()
+}
+
+type Producer struct {
+	b      *Broker
+	closed bool
+}
+
+func (b *Broker) NewProducer() *Producer {
+	return &Producer{b: b}
+}
+
+// Produce appends the message to its partition, picked by hashing the key or round robin without one, and
+// reports the delivery asynchronously like librdkafka does.
+func (p *Producer) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+	if msg.TopicPartition.Topic == nil {
+		return errors.New("fake kafka: message has no topic")
+	}
+	topic := *msg.TopicPartition.Topic
+
+	b := p.b
+	b.mu.Lock()
+	if p.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+	if b.produceErr != nil {
+		msg.TopicPartition.Error = b.produceErr
+	} else {
+		partition := msg.TopicPartition.Partition
+		if partition == kafka.PartitionAny {
+			if len(msg.Key) > 0 {
+				h := fnv.New32a()
+				h.Write(msg.Key)
+				partition = int32(h.Sum32() % uint32(b.partitions))
+			} else {
+				partition = b.next % b.partitions
+				b.next++
+			}
+		}
+		if partition < 0 || partition >= b.partitions {
+			b.mu.Unlock()
+			return errors.New("fake kafka: unknown partition")
+		}
+
+		log := b.topicLocked(topic)
+		stored := *msg
+		stored.TopicPartition = kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: kafka.Offset(len(log[partition]))}
+		if stored.Timestamp.IsZero() {
+			stored.Timestamp = time.Now()
+		}
+		log[partition] = append(log[partition], &stored)
+		msg.TopicPartition = stored.TopicPartition
+		b.notifyLocked()
+	}
+	b.mu.Unlock()
+
+	if deliveryChan != nil {
+		go func() { deliveryChan <- msg }()
+	}
+	return nil
+}
+
+func (p *Producer) GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error) {
+	return p.b.metadata(topic)
+}
+
+func (p *Producer) Close() {
+	p.b.mu.Lock()
+	p.closed = true
+	p.b.mu.Unlock()
+}
+
+type Consumer struct {
+	b     *Broker
+	group string
+	// reset is where to start without a committed offset, kafka.OffsetBeginning or kafka.OffsetEnd
+	reset kafka.Offset
+
+	topics     []string
+	assignment []topicPartition
+	positions  map[topicPartition]int64
+	cursor     int
+	closed     bool
+}
+
+func (b *Broker) NewConsumer(group string, reset kafka.Offset) *Consumer {
+	return &Consumer{b: b, group: group, reset: reset}
+}
+
+// SubscribeTopics joins the group, rebalancing it. Rebalance callbacks aren't supported.
+func (c *Consumer) SubscribeTopics(topics []string, rebalanceCb kafka.RebalanceCb) error {
+	b := c.b
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+
+	group, ok := b.groups[c.group]
+	if !ok {
+		group = &consumerGroup{committed: map[topicPartition]int64{}}
+		b.groups[c.group] = group
+	}
+	if c.topics == nil {
+		group.members = append(group.members, c)
+	}
+	c.topics = topics
+	b.rebalanceLocked(group)
+	return nil
+}
+
+// ReadMessage returns the next message of the assigned partitions, taking turns between them, and commits
+// its offset. A negative timeout waits forever.
+func (c *Consumer) ReadMessage(timeout time.Duration) (*kafka.Message, error) {
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	b := c.b
+	for {
+		b.mu.Lock()
+		if c.closed {
+			b.mu.Unlock()
+			return nil, ErrClosed
+		}
+		for i := range c.assignment {
+			fp := c.assignment[(c.cursor+i)%len(c.assignment)]
+			pos := c.positions[fp]
+			log := b.topics[fp.topic][fp.partition]
+			if pos >= int64(len(log)) {
+				continue
+			}
+
+			msg := *log[pos]
+			c.positions[fp] = pos + 1
+			b.groups[c.group].committed[fp] = pos + 1
+			c.cursor = (c.cursor + i + 1) % len(c.assignment)
+			b.mu.Unlock()
+			return &msg, nil
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-expired:
+			return nil, ErrTimeout
+		}
+	}
+}
+
+func (c *Consumer) Assignment() ([]kafka.TopicPartition, error) {
+	c.b.mu.Lock()
+	defer c.b.mu.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+
+	var tps []kafka.TopicPartition
+	for _, fp := range c.assignment {
+		topic := fp.topic
+		tps = append(tps, kafka.TopicPartition{Topic: &topic, Partition: fp.partition})
+	}
+	return tps, nil
+}
+
+func (c *Consumer) QueryWatermarkOffsets(topic string, partition int32, timeoutMs int) (int64, int64, error) {
+	c.b.mu.Lock()
+	defer c.b.mu.Unlock()
+	log := c.b.topicLocked(topic)
+	if partition < 0 || partition >= c.b.partitions {
+		return 0, 0, errors.New("fake kafka: unknown partition")
+	}
+	return 0, int64(len(log[partition])), nil
+}
+
+func (c *Consumer) GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error) {
+	return c.b.metadata(topic)
+}
+
+// Close leaves the group, its partitions go to the remaining members from the committed offsets.
+func (c *Consumer) Close() error {
+	b := c.b
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	c.closed = true
+
+	if group, ok := b.groups[c.group]; ok {
+		for i, m := range group.members {
+			if m == c {
+				group.members = append(group.members[:i], group.members[i+1:]...)
+				break
+			}
+		}
+		b.rebalanceLocked(group)
+	}
+	b.notifyLocked()
+	return nil
+}
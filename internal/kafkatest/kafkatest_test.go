@@ -0,0 +1,125 @@
+package kafkatest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func produce(t *testing.T, p *Producer, topic, key, value string) *kafka.Message {
+	t.Helper()
+	delivery := make(chan kafka.Event)
+	msg := &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny}, Value: []byte(value)}
+	if key != "" {
+		msg.Key = []byte(key)
+	}
+	if err := p.Produce(msg, delivery); err != nil {
+		t.Fatal(err)
+	}
+	return (<-delivery).(*kafka.Message)
+}
+
+func read(t *testing.T, c *Consumer) string {
+	t.Helper()
+	msg, err := c.ReadMessage(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(msg.Value)
+}
+
+func TestBrokerPartitionsAndOffsets(t *testing.T) {
+	b := NewBroker(3)
+	p := b.NewProducer()
+
+	first := produce(t, p, "t", "k", "a")
+	second := produce(t, p, "t", "k", "b")
+	if first.TopicPartition.Partition != second.TopicPartition.Partition {
+		t.Errorf("same key went to partitions %d and %d", first.TopicPartition.Partition, second.TopicPartition.Partition)
+	}
+	if first.TopicPartition.Offset != 0 || second.TopicPartition.Offset != 1 {
+		t.Errorf("offsets %v and %v, want 0 and 1", first.TopicPartition.Offset, second.TopicPartition.Offset)
+	}
+
+	seen := map[int32]bool{}
+	for i := 0; i < 3; i++ {
+		seen[produce(t, p, "t", "", "x").TopicPartition.Partition] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("keyless messages went to %d partitions, want 3", len(seen))
+	}
+
+	b.FailProduce(errors.New("broker down"))
+	if msg := produce(t, p, "t", "", "x"); msg.TopicPartition.Error == nil {
+		t.Error("delivery succeeded while failing")
+	}
+}
+
+func TestBrokerConsumerGroups(t *testing.T) {
+	b := NewBroker(2)
+	p := b.NewProducer()
+
+	c1 := b.NewConsumer("g", kafka.OffsetBeginning)
+	c2 := b.NewConsumer("g", kafka.OffsetBeginning)
+	other := b.NewConsumer("other", kafka.OffsetBeginning)
+	for _, c := range []*Consumer{c1, c2, other} {
+		if err := c.SubscribeTopics([]string{"t"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a1, _ := c1.Assignment()
+	a2, _ := c2.Assignment()
+	if len(a1) != 1 || len(a2) != 1 || a1[0].Partition == a2[0].Partition {
+		t.Fatalf("assignments %v and %v, want one partition each", a1, a2)
+	}
+
+	produce(t, p, "t", "", "a")
+	produce(t, p, "t", "", "b")
+	got := map[string]bool{read(t, c1): true, read(t, c2): true}
+	if !got["a"] || !got["b"] {
+		t.Errorf("group read %v, want a and b", got)
+	}
+	got = map[string]bool{read(t, other): true, read(t, other): true}
+	if !got["a"] || !got["b"] {
+		t.Errorf("other group read %v, want a and b", got)
+	}
+
+	if _, err := c1.ReadMessage(10 * time.Millisecond); err != ErrTimeout {
+		t.Errorf("read from an empty partition: %v, want a timeout", err)
+	}
+}
+
+func TestBrokerResumesFromCommittedOffsets(t *testing.T) {
+	b := NewBroker(1)
+	p := b.NewProducer()
+
+	c := b.NewConsumer("g", kafka.OffsetEnd)
+	c.SubscribeTopics([]string{"t"}, nil)
+	produce(t, p, "t", "", "a")
+	if v := read(t, c); v != "a" {
+		t.Fatalf("read %q, want a", v)
+	}
+
+	closed := make(chan error)
+	go func() {
+		_, err := c.ReadMessage(-1)
+		closed <- err
+	}()
+	c.Close()
+	if err := <-closed; err != ErrClosed {
+		t.Errorf("blocked read returned %v once closed", err)
+	}
+
+	// Produced while nobody consumes, then read by the next member from the committed offset
+	produce(t, p, "t", "", "b")
+	restarted := b.NewConsumer("g", kafka.OffsetEnd)
+	restarted.SubscribeTopics([]string{"t"}, nil)
+	if v := read(t, restarted); v != "b" {
+		t.Errorf("read %q after restart, want b", v)
+	}
+	if _, high, _ := restarted.QueryWatermarkOffsets("t", 0, 0); high != 2 {
+		t.Errorf("high watermark %d, want 2", high)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+const (
+	// CrossWired starts the error of replies carrying another inquiry's ID
+	CrossWired = "cross-wired reply"
+	// StreamTimeout is the error of streams ended by a timeout event, whose problem carries no ID
+	StreamTimeout = "stream timed out"
+)
+
+// idle is how long attacks wait before looking at the rate again while it's 0
+const idle = 10 * time.Millisecond
+
+// attacker sends the targets itself rather than through vegeta's, whose results don't tell which target they
+// answer, so that every reply can be checked against the inquiry ID it was asked for.
+type attacker struct {
+	client  http.Client
+	targets *targeter
+	name    string
+	seq     uint64
+}
+
+func newAttacker(targets *targeter, name string) *attacker {
+	return &attacker{
+		client: http.Client{
+			Timeout: vegeta.DefaultTimeout,
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				TLSClientConfig:     vegeta.DefaultTLSConfig,
+				TLSHandshakeTimeout: 10 * time.Second,
+				MaxIdleConnsPerHost: vegeta.DefaultConnections,
+			},
+		},
+		targets: targets,
+		name:    name,
+	}
+}
+
+// hit sends the next target and times its reply, the way vegeta does.
+func (a *attacker) hit() *vegeta.Result {
+	res := &vegeta.Result{Attack: a.name, Seq: atomic.AddUint64(&a.seq, 1) - 1}
+
+	var tgt vegeta.Target
+	id := a.targets.next(&tgt)
+	req, err := tgt.Request()
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	res.Timestamp = time.Now()
+	r, err := a.client.Do(req)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	defer r.Body.Close()
+
+	if res.Body, err = ioutil.ReadAll(r.Body); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Latency = time.Since(res.Timestamp)
+	res.BytesIn = uint64(len(res.Body))
+	if req.ContentLength != -1 {
+		res.BytesOut = uint64(req.ContentLength)
+	}
+
+	if res.Code = uint16(r.StatusCode); res.Code < 200 || res.Code >= 400 {
+		res.Error = r.Status
+	} else if id != "" {
+		if err := checkID(r.Header.Get("Content-Type"), res.Body, id); err != nil {
+			res.Error = err.Error()
+		}
+	}
+	return res
+}
+
+// checkID makes sure every JSON document of the reply, or every event of a stream, is about the inquiry asked for.
+func checkID(contentType string, body []byte, id string) error {
+	var docs [][]byte
+	timedOut := false
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		event := ""
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				event = ""
+			case strings.HasPrefix(line, "event:"):
+				event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+				timedOut = timedOut || event == "timeout"
+			case strings.HasPrefix(line, "data:") && event != "timeout":
+				docs = append(docs, []byte(strings.TrimPrefix(line, "data:")))
+			}
+		}
+	} else {
+		docs = [][]byte{body}
+	}
+
+	checked := 0
+	for _, doc := range docs {
+		dec := json.NewDecoder(bytes.NewReader(doc))
+		for {
+			var reply struct{ ID *string }
+			if err := dec.Decode(&reply); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("unreadable reply: %v", err)
+			}
+			if reply.ID == nil {
+				return fmt.Errorf("%s: no ID, asked for %s", CrossWired, id)
+			}
+			if *reply.ID != id {
+				return fmt.Errorf("%s: asked for %s, got %s", CrossWired, id, *reply.ID)
+			}
+			checked++
+		}
+	}
+	if timedOut {
+		return errors.New(StreamTimeout)
+	}
+	if checked == 0 {
+		return fmt.Errorf("%s: empty, asked for %s", CrossWired, id)
+	}
+	return nil
+}
+
+// attack hits the targets at the profile's rate for du. Each request has a goroutine of its own, so slow replies
+// never hold the next requests back.
+func attack(a *attacker, p RateProfile, du time.Duration) <-chan *vegeta.Result {
+	results := make(chan *vegeta.Result)
+	var hits sync.WaitGroup
+
+	go func() {
+		defer close(results)
+		defer hits.Wait()
+
+		began := time.Now()
+		for next := time.Duration(0); next < du; {
+			time.Sleep(time.Until(began.Add(next)))
+			rate := p.at(next, du)
+			if rate <= 0 {
+				next += idle
+				continue
+			}
+
+			hits.Add(1)
+			go func() {
+				defer hits.Done()
+				results <- a.hit()
+			}()
+			next += time.Duration(float64(time.Second) / rate)
+		}
+	}()
+
+	return results
+}
+
+// closedLoop has the users each send a request, wait for its reply and think before the next one until du is
+// over, so the rate is whatever the gateway keeps up with. Users don't think past du, and warn is told when
+// they spent longer waiting on replies than thinking, the gateway rather than -users bounding the rate then.
+func closedLoop(a *attacker, c ClosedLoop, du time.Duration, warn func(string)) <-chan *vegeta.Result {
+	results := make(chan *vegeta.Result)
+	var users sync.WaitGroup
+	var waited, replies int64
+
+	deadline := time.Now().Add(du)
+	for u := 0; u < c.Users; u++ {
+		users.Add(1)
+		go func() {
+			defer users.Done()
+			for time.Now().Before(deadline) {
+				res := a.hit()
+				atomic.AddInt64(&waited, int64(res.Latency))
+				atomic.AddInt64(&replies, 1)
+				results <- res
+
+				if !time.Now().Add(c.Think).Before(deadline) {
+					return
+				}
+				time.Sleep(c.Think)
+			}
+		}()
+	}
+	go func() {
+		users.Wait()
+		if warn != nil && replies > 0 && c.Think > 0 {
+			if mean := time.Duration(waited / replies); mean > c.Think {
+				warn(fmt.Sprintf("Warning: the %d users waited %s on average for a reply, longer than they think (%s). "+
+					"The gateway can't keep up with them, fewer users would reach the same rate.", c.Users, mean.Round(time.Millisecond), c.Think))
+			}
+		}
+		close(results)
+	}()
+
+	return results
+}
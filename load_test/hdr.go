@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math/bits"
+	"time"
+)
+
+// subBucketBits gives every power of two 2048 linear sub-buckets, so recorded values keep 3 significant digits.
+const subBucketBits = 11
+
+// histogram is an HDR histogram of latencies in microseconds: linear up to 2048µs, then log-linear, so it stays
+// precise and small from microseconds to hours. Counts grow with the largest value recorded.
+type histogram struct {
+	counts []uint64
+	total  uint64
+	min    uint64
+	max    uint64
+	sum    uint64
+}
+
+func bucketOf(v uint64) int {
+	if v < 1<<subBucketBits {
+		return int(v)
+	}
+	e := uint(bits.Len64(v) - subBucketBits)
+	return 1<<subBucketBits + int(e-1)<<(subBucketBits-1) + int(v>>e) - 1<<(subBucketBits-1)
+}
+
+// highestOf is the highest value recorded in the same bucket as the index.
+func highestOf(i int) uint64 {
+	if i < 1<<subBucketBits {
+		return uint64(i)
+	}
+	half := 1 << (subBucketBits - 1)
+	e := uint((i-1<<subBucketBits)/half + 1)
+	sub := uint64((i-1<<subBucketBits)%half + half)
+	return (sub+1)<<e - 1
+}
+
+func (h *histogram) record(d time.Duration) {
+	v := uint64(0)
+	if d > 0 {
+		v = uint64(d / time.Microsecond)
+	}
+	i := bucketOf(v)
+	if i >= len(h.counts) {
+		counts := make([]uint64, i+1)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[i]++
+
+	if h.total == 0 || v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	h.total++
+	h.sum += v
+}
+
+// percentile is the latency below which q percent of the recorded ones are, q being from 0 to 100.
+func (h *histogram) percentile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(q/100*float64(h.total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for i, c := range h.counts {
+		if seen += c; seen >= rank {
+			v := highestOf(i)
+			if v > h.max {
+				v = h.max
+			}
+			return time.Duration(v) * time.Microsecond
+		}
+	}
+	return h.maxLatency()
+}
+
+func (h *histogram) mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return time.Duration(h.sum/h.total) * time.Microsecond
+}
+
+func (h *histogram) minLatency() time.Duration { return time.Duration(h.min) * time.Microsecond }
+func (h *histogram) maxLatency() time.Duration { return time.Duration(h.max) * time.Microsecond }
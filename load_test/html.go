@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	chartWidth  = 900
+	chartHeight = 280
+	chartMargin = 50
+)
+
+// chart is a line chart drawn as SVG, so the HTML report needs nothing from the network.
+type chart struct {
+	Title  string
+	Unit   string
+	Width  int
+	Height int
+	Margin int
+	Series []series
+	// YTicks and XTicks are labelled gridlines
+	YTicks []tick
+	XTicks []tick
+}
+
+type series struct {
+	Name   string
+	Color  string
+	Points string
+}
+
+type tick struct {
+	Pos   float64
+	Label string
+}
+
+// newChart plots the series' values, one per window, over the attack.
+func newChart(title, unit string, windows []Window, names, colors []string, values func(w Window) []float64) chart {
+	c := chart{Title: title, Unit: unit, Width: chartWidth, Height: chartHeight, Margin: chartMargin}
+
+	top := 0.0
+	for _, w := range windows {
+		for _, v := range values(w) {
+			top = math.Max(top, v)
+		}
+	}
+	if top == 0 {
+		top = 1
+	}
+
+	plotW, plotH := float64(chartWidth-2*chartMargin), float64(chartHeight-2*chartMargin)
+	x := func(i int) float64 {
+		if len(windows) < 2 {
+			return chartMargin
+		}
+		return chartMargin + plotW*float64(i)/float64(len(windows)-1)
+	}
+	y := func(v float64) float64 { return chartMargin + plotH*(1-v/top) }
+
+	points := make([][]string, len(names))
+	for i, w := range windows {
+		for s, v := range values(w) {
+			points[s] = append(points[s], fmt.Sprintf("%.1f,%.1f", x(i), y(v)))
+		}
+	}
+	for s, name := range names {
+		c.Series = append(c.Series, series{Name: name, Color: colors[s], Points: strings.Join(points[s], " ")})
+	}
+
+	for k := 0; k <= 4; k++ {
+		v := top * float64(k) / 4
+		c.YTicks = append(c.YTicks, tick{Pos: y(v), Label: fmt.Sprintf("%.4g", v)})
+	}
+	for k := 0; k <= 4 && len(windows) > 0; k++ {
+		i := (len(windows) - 1) * k / 4
+		c.XTicks = append(c.XTicks, tick{Pos: x(i), Label: windows[i].Start.String()})
+	}
+	return c
+}
+
+func ms(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"add": func(a, b int) int { return a + b },
+	"sub": func(a, b int) int { return a - b },
+	"mul": func(a, b int) int { return a * b },
+}).Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { padding: 4px 12px; text-align: left; border-bottom: 1px solid #ddd; }
+svg text { font-size: 11px; fill: #555; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{with .Summary}}
+<table>
+<tr><th>Requests</th><td>{{.Requests}}</td></tr>
+<tr><th>Duration</th><td>{{.Duration}} attacking, {{.Wait}} waiting</td></tr>
+<tr><th>Rate</th><td>{{printf "%.2f" .Rate}}/s</td></tr>
+<tr><th>Throughput</th><td>{{printf "%.2f" .Throughput}}/s</td></tr>
+<tr><th>Success</th><td>{{printf "%.2f" .Success}}</td></tr>
+<tr><th>Mismatches</th><td>{{.Mismatches}}</td></tr>
+{{with .Latencies}}<tr><th>Latencies</th><td>min {{.Min}}, mean {{.Mean}}, 50th {{.P50}}, 90th {{.P90}}, 95th {{.P95}}, 99th {{.P99}}, 99.9th {{.P999}}, max {{.Max}}</td></tr>{{end}}
+<tr><th>Status codes</th><td>{{range $code, $n := .StatusCodes}}{{$code}}: {{$n}} &nbsp; {{end}}</td></tr>
+<tr><th>Errors</th><td>{{range $err, $n := .Errors}}{{$err}}: {{$n}}<br>{{end}}</td></tr>
+</table>
+{{end}}
+{{range .Charts}}
+<h2>{{.Title}}</h2>
+<svg width="{{.Width}}" height="{{.Height}}" xmlns="http://www.w3.org/2000/svg">
+{{- $c := .}}
+{{- range .YTicks}}
+<line x1="{{$c.Margin}}" x2="{{printf "%d" (sub $c.Width $c.Margin)}}" y1="{{.Pos}}" y2="{{.Pos}}" stroke="#eee"/>
+<text x="{{printf "%d" (sub $c.Margin 5)}}" y="{{.Pos}}" text-anchor="end" dominant-baseline="middle">{{.Label}}</text>
+{{- end}}
+{{- range .XTicks}}
+<text x="{{.Pos}}" y="{{printf "%d" (sub $c.Height 25)}}" text-anchor="middle">{{.Label}}</text>
+{{- end}}
+<text x="5" y="15">{{.Unit}}</text>
+{{- range $i, $s := .Series}}
+<polyline fill="none" stroke="{{$s.Color}}" stroke-width="1.5" points="{{$s.Points}}"/>
+<text x="{{printf "%d" (add $c.Margin (mul $i 110))}}" y="{{printf "%d" (sub $c.Height 5)}}" style="fill: {{$s.Color}}">&#9632; {{$s.Name}}</text>
+{{- end}}
+</svg>
+{{end}}
+</body>
+</html>
+`))
+
+func writeHTML(w io.Writer, title string, s Summary, window time.Duration) error {
+	return htmlReport.Execute(w, struct {
+		Title   string
+		Summary Summary
+		Charts  []chart
+	}{
+		Title:   title,
+		Summary: s,
+		Charts: []chart{
+			newChart("Latencies over time", "ms", s.Windows,
+				[]string{"50th", "90th", "99th", "max"},
+				[]string{"#1f77b4", "#2ca02c", "#ff7f0e", "#d62728"},
+				func(w Window) []float64 {
+					return []float64{ms(w.Latencies.P50), ms(w.Latencies.P90), ms(w.Latencies.P99), ms(w.Latencies.Max)}
+				}),
+			newChart("Rate and throughput", "per second", s.Windows,
+				[]string{"rate", "throughput", "errors"},
+				[]string{"#1f77b4", "#2ca02c", "#d62728"},
+				func(w Window) []float64 { return []float64{w.Rate, w.Throughput, float64(w.Errors) / window.Seconds()} }),
+		},
+	})
+}
@@ -4,33 +4,234 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	vegeta "github.com/tsenart/vegeta/lib"
 )
 
+// headerFlags collects repeated -H "Key: Value" flags.
+type headerFlags map[string]string
+
+func (h headerFlags) String() string { return fmt.Sprint(map[string]string(h)) }
+
+func (h headerFlags) Set(v string) error {
+	kv := strings.SplitN(v, ":", 2)
+	if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+		return fmt.Errorf("expected \"Key: Value\", got %q", v)
+	}
+	h[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	return nil
+}
+
 func main() {
-	rps := flag.Int("rps", 100, "Request per Second")
+	scenarioPath := flag.String("scenario", "", "YAML scenario file, overridden by the flags set explicitly")
+	baseURL := flag.String("baseURL", "http://localhost:8080", "Gateway to attack")
+	path := flag.String("path", "/inquiry/"+IDPlaceholder, "Path requested, "+IDPlaceholder+" being replaced by the inquiry ID")
+	method := flag.String("method", "GET", "HTTP method")
+	body := flag.String("body", "", "Request body, "+IDPlaceholder+" being replaced by the inquiry ID")
+	bodyFile := flag.String("bodyFile", "", "File holding the request body")
+	headers := headerFlags{}
+	flag.Var(headers, "H", "Request header as \"Key: Value\", repeatable")
+
+	mode := flag.String("mode", ModeOpen, "open: requests at the profile's rate, closed: virtual users waiting for their replies, search: look for the knee point")
+	rps := flag.Float64("rps", 100, "Request per Second, the rate ramps, steps, spikes and searches start from and sines oscillate around")
 	duration := flag.Duration("dur", 5*time.Second, "How long the test going to be performed")
 	varianceNum := flag.Int("vars", 1000000, "Total num of variance on the request")
+	random := flag.Bool("random", false, "Request the IDs at random instead of in turn")
+
+	profile := flag.String("profile", ProfileConstant, "Rate profile: constant, ramp, step, sine or spike")
+	peak := flag.Float64("peak", 0, "Rate ramps end at, steps stop at and spikes reach")
+	step := flag.Float64("step", 0, "Rate added at each step")
+	every := flag.Duration("every", 10*time.Second, "Time between steps or between spikes")
+	amplitude := flag.Float64("amplitude", 0, "Amplitude of sines")
+	period := flag.Duration("period", time.Minute, "Period of sines")
+	spikeFor := flag.Duration("spikeFor", time.Second, "How long spikes last")
+
+	users := flag.Int("users", 10, "Virtual users of closed loops")
+	think := flag.Duration("think", 0, "Time virtual users think between a reply and their next request")
+
+	searchStep := flag.Float64("searchStep", 100, "Rate added at each step of searches")
+	searchFor := flag.Duration("searchFor", 10*time.Second, "How long searches try each rate")
+	maxRate := flag.Float64("maxRate", 0, "Rate searches stop at even within the thresholds, 0 for none")
+	maxP99 := flag.Duration("maxP99", time.Second, "Highest 99th percentile latency searches accept, 0 for any")
+	maxErrors := flag.Float64("maxErrors", 0.01, "Highest ratio of failed requests searches accept")
+	refine := flag.Int("refine", 2, "Times searches bisect between the last rate within the thresholds and the first beyond")
+
+	reportFormat := flag.String("report", "", "Report written to stdout instead of the raw results: text, json or html, searches reporting as text or json")
+	resultsPath := flag.String("results", "", "File the raw results are written to along with the report")
+	window := flag.Duration("window", time.Second, "Time slices the report follows the latencies and throughput over")
 
 	flag.Parse()
 
-	targets := []vegeta.Target{}
-	for i := 0; i < *varianceNum; i++ {
-		targets = append(targets, vegeta.Target{
-			Method: "GET",
-			URL:    fmt.Sprintf("http://localhost:8080/inquiry/%d", i),
-		})
+	sc := defaultScenario()
+	if *scenarioPath != "" {
+		if err := loadScenario(*scenarioPath, &sc); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	// Flags set explicitly win over the scenario, the route ones replacing its routes with a single one
+	route := defaultRoute()
+	routeSet := false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "baseURL":
+			sc.BaseURL = *baseURL
+		case "path":
+			route.Path, routeSet = *path, true
+		case "method":
+			route.Method, routeSet = *method, true
+		case "body":
+			route.Body, routeSet = *body, true
+		case "bodyFile":
+			route.BodyFile, routeSet = *bodyFile, true
+		case "H":
+			if sc.Headers == nil {
+				sc.Headers = map[string]string{}
+			}
+			for k, v := range headers {
+				sc.Headers[k] = v
+			}
+		case "mode":
+			sc.Mode = *mode
+		case "rps":
+			sc.Rate.Rate = *rps
+			sc.Search.Rate = *rps
+		case "dur":
+			sc.Duration = *duration
+		case "vars":
+			sc.IDs.Count = *varianceNum
+		case "random":
+			sc.IDs.Random = *random
+		case "profile":
+			sc.Rate.Shape = *profile
+		case "peak":
+			sc.Rate.Peak = *peak
+		case "step":
+			sc.Rate.Step = *step
+		case "every":
+			sc.Rate.Every = *every
+		case "amplitude":
+			sc.Rate.Amplitude = *amplitude
+		case "period":
+			sc.Rate.Period = *period
+		case "spikeFor":
+			sc.Rate.SpikeFor = *spikeFor
+		case "users":
+			sc.Closed.Users = *users
+		case "think":
+			sc.Closed.Think = *think
+		case "searchStep":
+			sc.Search.Step = *searchStep
+		case "searchFor":
+			sc.Search.StepFor = *searchFor
+		case "maxRate":
+			sc.Search.MaxRate = *maxRate
+		case "maxP99":
+			sc.Search.P99 = *maxP99
+		case "maxErrors":
+			sc.Search.ErrorRate = *maxErrors
+		case "refine":
+			sc.Search.Refine = *refine
+		}
+	})
+	if routeSet {
+		sc.Routes = []Route{route}
+	}
+	// Without a scenario, the defaults of the profile flags apply
+	if *scenarioPath == "" {
+		if sc.Rate.Every == 0 {
+			sc.Rate.Every = *every
+		}
+		if sc.Rate.Period == 0 {
+			sc.Rate.Period = *period
+		}
+		if sc.Rate.SpikeFor == 0 {
+			sc.Rate.SpikeFor = *spikeFor
+		}
+	}
+
+	if err := sc.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	switch *reportFormat {
+	case "", ReportText, ReportJSON, ReportHTML:
+	default:
+		fmt.Fprintln(os.Stderr, "-report must be text, json or html")
+		os.Exit(2)
+	}
+	if *window <= 0 {
+		fmt.Fprintln(os.Stderr, "-window must be positive")
+		os.Exit(2)
 	}
 
-	rate := uint64(*rps)
-	targeter := vegeta.NewStaticTargeter(targets...)
-	attacker := vegeta.NewAttacker()
+	attacker := newAttacker(newTargeter(&sc), "Inquiry Test")
 
-	enc := vegeta.NewEncoder(os.Stdout)
+	if sc.Mode == ModeSearch {
+		runSearch(attacker, sc.Search, *reportFormat, *resultsPath)
+		return
+	}
 
-	for res := range attacker.Attack(targeter, rate, *duration, "Inquiry Test") {
+	began := time.Now()
+	var results <-chan *vegeta.Result
+	if sc.Mode == ModeClosed {
+		results = closedLoop(attacker, sc.Closed, sc.Duration, func(msg string) { fmt.Fprintln(os.Stderr, msg) })
+	} else {
+		results = attack(attacker, sc.Rate, sc.Duration)
+	}
+
+	if *reportFormat == "" {
+		enc := vegeta.NewEncoder(os.Stdout)
+		for res := range results {
+			enc.Encode(res)
+		}
+		return
+	}
+
+	enc := resultsEncoder(*resultsPath)
+	rep := newReport(began, *window)
+	for res := range results {
+		rep.add(res)
 		enc.Encode(res)
 	}
+	if err := rep.write(os.Stdout, *reportFormat, "Inquiry Test"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// runSearch reports each step on stderr as it completes and the knee point on stdout.
+func runSearch(attacker *attacker, s Search, format, resultsPath string) {
+	if format == ReportHTML {
+		fmt.Fprintln(os.Stderr, "searches report as text or json")
+		os.Exit(2)
+	}
+
+	enc := resultsEncoder(resultsPath)
+	result := search(attacker, s, func(res *vegeta.Result) { enc.Encode(res) }, func(step SearchStep) {
+		fmt.Fprintln(os.Stderr, step)
+	})
+
+	if err := result.write(os.Stdout, format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// resultsEncoder writes the raw results to the file, discarding them without one.
+func resultsEncoder(path string) vegeta.Encoder {
+	if path == "" {
+		return func(*vegeta.Result) error { return nil }
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// The file is closed as the process exits
+	return vegeta.NewEncoder(f)
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// Shapes of the request rate over the attack
+	ProfileConstant = "constant"
+	ProfileRamp     = "ramp"
+	ProfileStep     = "step"
+	ProfileSine     = "sine"
+	ProfileSpike    = "spike"
+)
+
+// RateProfile is the request rate per second over the attack.
+type RateProfile struct {
+	// Shape is constant, ramp, step, sine or spike
+	Shape string `yaml:"shape"`
+	// Rate is the constant rate, the one ramps and steps start from, sines oscillate around and spikes rise from
+	Rate float64 `yaml:"rate"`
+	// Peak is the rate ramps end at, steps stop at and spikes reach
+	Peak float64 `yaml:"peak"`
+	// Step is added to the rate Every so often
+	Step float64 `yaml:"step"`
+	// Every is the time between steps or between spikes
+	Every time.Duration `yaml:"every"`
+	// Amplitude and Period shape sines
+	Amplitude float64       `yaml:"amplitude"`
+	Period    time.Duration `yaml:"period"`
+	// SpikeFor is how long spikes last
+	SpikeFor time.Duration `yaml:"spikeFor"`
+}
+
+func (p *RateProfile) validate() error {
+	if p.Rate < 0 || p.Peak < 0 {
+		return fmt.Errorf("rate.rate and rate.peak can't be negative")
+	}
+
+	switch p.Shape {
+	case ProfileConstant:
+	case ProfileRamp:
+	case ProfileStep:
+		if p.Every <= 0 {
+			return fmt.Errorf("rate.every must be positive with steps")
+		}
+	case ProfileSine:
+		if p.Period <= 0 || p.Amplitude < 0 {
+			return fmt.Errorf("rate.period must be positive and rate.amplitude can't be negative with sines")
+		}
+	case ProfileSpike:
+		if p.Every <= 0 || p.SpikeFor <= 0 || p.SpikeFor > p.Every {
+			return fmt.Errorf("rate.every and rate.spikeFor must be positive, spikes lasting at most every")
+		}
+	default:
+		return fmt.Errorf("rate.shape must be constant, ramp, step, sine or spike")
+	}
+	return nil
+}
+
+// at is the rate elapsed into an attack lasting total, never negative.
+func (p *RateProfile) at(elapsed, total time.Duration) float64 {
+	var rate float64
+	switch p.Shape {
+	case ProfileRamp:
+		rate = p.Rate + (p.Peak-p.Rate)*float64(elapsed)/float64(total)
+	case ProfileStep:
+		rate = p.Rate + p.Step*float64(elapsed/p.Every)
+		if p.Peak > 0 && rate > p.Peak {
+			rate = p.Peak
+		}
+	case ProfileSine:
+		rate = p.Rate + p.Amplitude*math.Sin(2*math.Pi*float64(elapsed)/float64(p.Period))
+	case ProfileSpike:
+		// The first spike comes after a calm interval
+		rate = p.Rate
+		if elapsed >= p.Every && elapsed%p.Every < p.SpikeFor {
+			rate = p.Peak
+		}
+	default:
+		rate = p.Rate
+	}
+	return math.Max(rate, 0)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateProfiles(t *testing.T) {
+	total := 60 * time.Second
+	cases := []struct {
+		name    string
+		profile RateProfile
+		elapsed time.Duration
+		want    float64
+	}{
+		{"constant", RateProfile{Shape: ProfileConstant, Rate: 100}, 30 * time.Second, 100},
+		{"ramp start", RateProfile{Shape: ProfileRamp, Rate: 100, Peak: 700}, 0, 100},
+		{"ramp middle", RateProfile{Shape: ProfileRamp, Rate: 100, Peak: 700}, 30 * time.Second, 400},
+		{"ramp down", RateProfile{Shape: ProfileRamp, Rate: 700, Peak: 100}, 45 * time.Second, 250},
+		{"first step", RateProfile{Shape: ProfileStep, Rate: 100, Step: 50, Every: 10 * time.Second}, 9 * time.Second, 100},
+		{"third step", RateProfile{Shape: ProfileStep, Rate: 100, Step: 50, Every: 10 * time.Second}, 25 * time.Second, 200},
+		{"step capped", RateProfile{Shape: ProfileStep, Rate: 100, Step: 50, Every: 10 * time.Second, Peak: 150}, 55 * time.Second, 150},
+		{"sine crest", RateProfile{Shape: ProfileSine, Rate: 100, Amplitude: 50, Period: 20 * time.Second}, 5 * time.Second, 150},
+		{"sine trough", RateProfile{Shape: ProfileSine, Rate: 100, Amplitude: 50, Period: 20 * time.Second}, 15 * time.Second, 50},
+		{"sine never negative", RateProfile{Shape: ProfileSine, Rate: 10, Amplitude: 50, Period: 20 * time.Second}, 15 * time.Second, 0},
+		{"calm before the first spike", RateProfile{Shape: ProfileSpike, Rate: 100, Peak: 1000, Every: 20 * time.Second, SpikeFor: 2 * time.Second}, time.Second, 100},
+		{"spike", RateProfile{Shape: ProfileSpike, Rate: 100, Peak: 1000, Every: 20 * time.Second, SpikeFor: 2 * time.Second}, 41 * time.Second, 1000},
+		{"after a spike", RateProfile{Shape: ProfileSpike, Rate: 100, Peak: 1000, Every: 20 * time.Second, SpikeFor: 2 * time.Second}, 43 * time.Second, 100},
+	}
+
+	for _, tc := range cases {
+		if err := tc.profile.validate(); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := tc.profile.at(tc.elapsed, total); got < tc.want-0.001 || got > tc.want+0.001 {
+			t.Errorf("%s: rate %.3f at %s, want %.3f", tc.name, got, tc.elapsed, tc.want)
+		}
+	}
+}
+
+func TestRateProfileValidation(t *testing.T) {
+	for name, p := range map[string]RateProfile{
+		"unknown shape":         {Shape: "square", Rate: 1},
+		"negative rate":         {Shape: ProfileConstant, Rate: -1},
+		"step without every":    {Shape: ProfileStep, Rate: 1, Step: 1},
+		"sine without period":   {Shape: ProfileSine, Rate: 1, Amplitude: 1},
+		"spike longer than gap": {Shape: ProfileSpike, Rate: 1, Peak: 2, Every: time.Second, SpikeFor: 2 * time.Second},
+	} {
+		if err := p.validate(); err == nil {
+			t.Errorf("%s: valid", name)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+const (
+	// Formats of the report
+	ReportText = "text"
+	ReportJSON = "json"
+	ReportHTML = "html"
+)
+
+// report aggregates the results as they arrive, over the whole attack and per window of time.
+type report struct {
+	began  time.Time
+	window time.Duration
+
+	latencies  histogram
+	windows    []*windowStats
+	codes      map[string]uint64
+	errors     map[string]uint64
+	requests   uint64
+	successes  uint64
+	mismatches uint64
+	bytesIn    uint64
+	bytesOut   uint64
+
+	earliest time.Time
+	latest   time.Time
+	end      time.Time
+}
+
+type windowStats struct {
+	latencies histogram
+	requests  uint64
+	successes uint64
+	errors    uint64
+}
+
+func newReport(began time.Time, window time.Duration) *report {
+	return &report{began: began, window: window, codes: map[string]uint64{}, errors: map[string]uint64{}}
+}
+
+func (r *report) add(res *vegeta.Result) {
+	at := res.Timestamp
+	if at.IsZero() {
+		// The request couldn't be sent
+		at = time.Now()
+	}
+	i := int(at.Sub(r.began) / r.window)
+	if i < 0 {
+		i = 0
+	}
+	for len(r.windows) <= i {
+		r.windows = append(r.windows, &windowStats{})
+	}
+	w := r.windows[i]
+
+	r.requests++
+	w.requests++
+	r.codes[strconv.Itoa(int(res.Code))]++
+	r.bytesIn += res.BytesIn
+	r.bytesOut += res.BytesOut
+	if res.Code != 0 {
+		r.latencies.record(res.Latency)
+		w.latencies.record(res.Latency)
+	}
+
+	switch {
+	case res.Error == "":
+		r.successes++
+		w.successes++
+	case strings.HasPrefix(res.Error, CrossWired):
+		// Each of them names its IDs, they're counted as one kind of error
+		r.mismatches++
+		r.errors[CrossWired]++
+		w.errors++
+	default:
+		r.errors[res.Error]++
+		w.errors++
+	}
+
+	if r.earliest.IsZero() || at.Before(r.earliest) {
+		r.earliest = at
+	}
+	if at.After(r.latest) {
+		r.latest = at
+	}
+	if end := at.Add(res.Latency); end.After(r.end) {
+		r.end = end
+	}
+}
+
+// Summary is what the report tells, in JSON too.
+type Summary struct {
+	Requests uint64 `json:"requests"`
+	// Duration is the time requests were sent over, Wait the time spent waiting for the last replies afterwards
+	Duration time.Duration `json:"duration"`
+	Wait     time.Duration `json:"wait"`
+	// Rate is the requests per second sent, Throughput the successful ones per second replied
+	Rate       float64 `json:"rate"`
+	Throughput float64 `json:"throughput"`
+	Success    float64 `json:"success"`
+	// Mismatches are replies carrying another inquiry's ID
+	Mismatches  uint64            `json:"mismatches"`
+	BytesIn     uint64            `json:"bytes_in"`
+	BytesOut    uint64            `json:"bytes_out"`
+	Latencies   Latencies         `json:"latencies"`
+	StatusCodes map[string]uint64 `json:"status_codes"`
+	Errors      map[string]uint64 `json:"errors"`
+	Windows     []Window          `json:"windows"`
+}
+
+// Latencies are percentiles of the replies' latencies.
+type Latencies struct {
+	Min  time.Duration `json:"min"`
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"50th"`
+	P90  time.Duration `json:"90th"`
+	P95  time.Duration `json:"95th"`
+	P99  time.Duration `json:"99th"`
+	P999 time.Duration `json:"99.9th"`
+	Max  time.Duration `json:"max"`
+}
+
+// Window is one slice of time of the attack, starting Start after its beginning.
+type Window struct {
+	Start      time.Duration `json:"start"`
+	Requests   uint64        `json:"requests"`
+	Rate       float64       `json:"rate"`
+	Throughput float64       `json:"throughput"`
+	Errors     uint64        `json:"errors"`
+	Latencies  Latencies     `json:"latencies"`
+}
+
+func latenciesOf(h *histogram) Latencies {
+	return Latencies{
+		Min:  h.minLatency(),
+		Mean: h.mean(),
+		P50:  h.percentile(50),
+		P90:  h.percentile(90),
+		P95:  h.percentile(95),
+		P99:  h.percentile(99),
+		P999: h.percentile(99.9),
+		Max:  h.maxLatency(),
+	}
+}
+
+func (r *report) summary() Summary {
+	s := Summary{
+		Requests:    r.requests,
+		Duration:    r.latest.Sub(r.earliest),
+		Mismatches:  r.mismatches,
+		BytesIn:     r.bytesIn,
+		BytesOut:    r.bytesOut,
+		Latencies:   latenciesOf(&r.latencies),
+		StatusCodes: r.codes,
+		Errors:      r.errors,
+	}
+	if r.end.After(r.latest) {
+		s.Wait = r.end.Sub(r.latest)
+	}
+	if s.Duration > 0 {
+		s.Rate = float64(r.requests) / s.Duration.Seconds()
+	}
+	if total := s.Duration + s.Wait; total > 0 {
+		s.Throughput = float64(r.successes) / total.Seconds()
+	}
+	if r.requests > 0 {
+		s.Success = float64(r.successes) / float64(r.requests)
+	}
+
+	for i, w := range r.windows {
+		s.Windows = append(s.Windows, Window{
+			Start:      time.Duration(i) * r.window,
+			Requests:   w.requests,
+			Rate:       float64(w.requests) / r.window.Seconds(),
+			Throughput: float64(w.successes) / r.window.Seconds(),
+			Errors:     w.errors,
+			Latencies:  latenciesOf(&w.latencies),
+		})
+	}
+	return s
+}
+
+func (r *report) write(w io.Writer, format, title string) error {
+	s := r.summary()
+	switch format {
+	case ReportJSON:
+		return json.NewEncoder(w).Encode(s)
+	case ReportHTML:
+		return writeHTML(w, title, s, r.window)
+	default:
+		return writeText(w, s)
+	}
+}
+
+func writeText(w io.Writer, s Summary) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	l := s.Latencies
+	fmt.Fprintf(tw, "Requests\t[total, rate, throughput]\t%d, %.2f, %.2f\n", s.Requests, s.Rate, s.Throughput)
+	fmt.Fprintf(tw, "Duration\t[total, attack, wait]\t%s, %s, %s\n", s.Duration+s.Wait, s.Duration, s.Wait)
+	fmt.Fprintf(tw, "Latencies\t[min, mean, 50, 90, 95, 99, 99.9, max]\t%s, %s, %s, %s, %s, %s, %s, %s\n",
+		l.Min, l.Mean, l.P50, l.P90, l.P95, l.P99, l.P999, l.Max)
+	fmt.Fprintf(tw, "Bytes In\t[total]\t%d\n", s.BytesIn)
+	fmt.Fprintf(tw, "Bytes Out\t[total]\t%d\n", s.BytesOut)
+	fmt.Fprintf(tw, "Success\t[ratio]\t%.2f%%\n", s.Success*100)
+	fmt.Fprintf(tw, "Mismatches\t[cross-wired replies]\t%d\n", s.Mismatches)
+	fmt.Fprintf(tw, "Status Codes\t[code:count]\t%s\n", counts(s.StatusCodes, "  "))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "Error Set:")
+	fmt.Fprint(w, counts(s.Errors, "\n"))
+	if len(s.Errors) > 0 {
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintln(w, "\nOver time:")
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "start\trate\tthroughput\terrors\t50\t90\t99\tmax\t")
+	for _, win := range s.Windows {
+		fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t%d\t%s\t%s\t%s\t%s\t\n", win.Start, win.Rate, win.Throughput, win.Errors,
+			win.Latencies.P50, win.Latencies.P90, win.Latencies.P99, win.Latencies.Max)
+	}
+	return tw.Flush()
+}
+
+// counts lists "key:count" pairs by key.
+func counts(m map[string]uint64, sep string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s:%d", k, m[k])
+	}
+	return strings.Join(pairs, sep)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogramPercentiles(t *testing.T) {
+	var h histogram
+	// 1ms to 10s, 10000 latencies a millisecond apart
+	for i := 1; i <= 10000; i++ {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+
+	for _, tc := range []struct {
+		q    float64
+		want time.Duration
+	}{{50, 5 * time.Second}, {90, 9 * time.Second}, {99, 9900 * time.Millisecond}, {99.9, 9990 * time.Millisecond}, {100, 10 * time.Second}} {
+		// 3 significant digits
+		if got := h.percentile(tc.q); math.Abs(float64(got-tc.want)) > 0.001*float64(tc.want) {
+			t.Errorf("%gth = %s, want %s", tc.q, got, tc.want)
+		}
+	}
+	if h.minLatency() != time.Millisecond || h.maxLatency() != 10*time.Second {
+		t.Errorf("min %s and max %s", h.minLatency(), h.maxLatency())
+	}
+	if mean := h.mean(); mean != 5000500*time.Microsecond {
+		t.Errorf("mean %s", mean)
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	for _, v := range []uint64{0, 1, 2047, 2048, 2049, 4095, 4096, 123456789, 1 << 40} {
+		if high := highestOf(bucketOf(v)); high < v || bucketOf(high) != bucketOf(v) || bucketOf(high+1) == bucketOf(v) {
+			t.Errorf("%d: highest of its bucket %d", v, high)
+		}
+	}
+}
+
+func TestCheckID(t *testing.T) {
+	for _, tc := range []struct {
+		name, contentType, body string
+		wantErr                 string
+	}{
+		{"matching", "application/json", `{"ID": "42", "Name": "x"}`, ""},
+		{"cross-wired", "application/json", `{"ID": "43"}`, CrossWired},
+		{"no ID", "application/json", `{"Name": "x"}`, CrossWired},
+		{"empty", "application/json", ``, CrossWired},
+		{"second document cross-wired", "application/json", `{"ID": "42"}{"ID": "43"}`, CrossWired},
+		{"not JSON", "text/plain", `ok`, "unreadable reply"},
+		{"stream", "text/event-stream", "id: 0\nevent: partial\ndata: {\"ID\": \"42\"}\n\nid: 1\nevent: final\ndata: {\"ID\": \"42\"}\n\n", ""},
+		{"stream cross-wired", "text/event-stream", "id: 0\nevent: final\ndata: {\"ID\": \"7\"}\n\n", CrossWired},
+		{"stream timed out", "text/event-stream", "id: 0\nevent: partial\ndata: {\"ID\": \"42\"}\n\nevent: timeout\ndata: {\"type\": \"urn:inquiry:problem:timeout\"}\n\n", StreamTimeout},
+		{"stream timed out early", "text/event-stream", "event: timeout\ndata: {\"type\": \"urn:inquiry:problem:timeout\"}\n\n", StreamTimeout},
+		{"stream cross-wired then timed out", "text/event-stream", "id: 0\nevent: partial\ndata: {\"ID\": \"7\"}\n\nevent: timeout\ndata: {}\n\n", CrossWired},
+	} {
+		err := checkID(tc.contentType, []byte(tc.body), "42")
+		switch {
+		case tc.wantErr == "" && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case tc.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), tc.wantErr)):
+			t.Errorf("%s: error %v, want %s", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+// TestReportCatchesCrossWiredReplies attacks a gateway answering every third inquiry with the wrong ID.
+func TestReportCatchesCrossWiredReplies(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/inquiry/")
+		if id == "404" {
+			http.NotFound(w, r)
+			return
+		}
+		if strings.HasSuffix(id, "3") || strings.HasSuffix(id, "6") || strings.HasSuffix(id, "9") {
+			id += "0"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"ID": %q}`, id)
+	}))
+	defer gateway.Close()
+
+	sc := defaultScenario()
+	sc.BaseURL = gateway.URL
+	sc.IDs = IDRange{From: 400, Count: 10}
+	sc.Duration = time.Second
+	sc.Rate = RateProfile{Shape: ProfileConstant, Rate: 100}
+	if err := sc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	began := time.Now()
+	rep := newReport(began, 250*time.Millisecond)
+	for res := range attack(newAttacker(newTargeter(&sc), "test"), sc.Rate, sc.Duration) {
+		rep.add(res)
+	}
+	s := rep.summary()
+
+	// IDs 400 to 409 in turn: 403, 406 and 409 come back cross-wired, 404 isn't found
+	if s.Requests != 100 || s.Mismatches != 30 || s.StatusCodes["404"] != 10 || s.StatusCodes["200"] != 90 {
+		t.Errorf("%d requests, %d mismatches, status codes %v", s.Requests, s.Mismatches, s.StatusCodes)
+	}
+	if s.Success != 0.6 || s.Errors[CrossWired] != 30 {
+		t.Errorf("success %.2f, errors %v", s.Success, s.Errors)
+	}
+	if len(s.Windows) < 4 || s.Windows[0].Requests == 0 || s.Latencies.P99 == 0 {
+		t.Errorf("windows %+v, latencies %+v", s.Windows, s.Latencies)
+	}
+
+	for _, format := range []string{ReportText, ReportJSON, ReportHTML} {
+		var out bytes.Buffer
+		if err := rep.write(&out, format, "Inquiry Test"); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		switch format {
+		case ReportJSON:
+			var decoded Summary
+			if err := json.Unmarshal(out.Bytes(), &decoded); err != nil || decoded.Mismatches != 30 {
+				t.Errorf("json: %v, %d mismatches", err, decoded.Mismatches)
+			}
+		case ReportText:
+			if !strings.Contains(out.String(), "Mismatches") || !strings.Contains(out.String(), "Over time:") {
+				t.Errorf("text:\n%s", out.String())
+			}
+		case ReportHTML:
+			if strings.Count(out.String(), "<polyline") != 7 {
+				t.Errorf("html:\n%s", out.String())
+			}
+		}
+	}
+}
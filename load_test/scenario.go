@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// IDPlaceholder is replaced by the inquiry ID in paths, bodies and header values
+const IDPlaceholder = "{id}"
+
+const (
+	// Modes of the load test: requests at a given rate, virtual users waiting for their replies, or a search
+	// for the highest rate within latency and error thresholds
+	ModeOpen   = "open"
+	ModeClosed = "closed"
+	ModeSearch = "search"
+)
+
+// Scenario describes the traffic sent to the gateway: where, which requests and at which rate.
+type Scenario struct {
+	BaseURL string `yaml:"baseURL"`
+	// Headers are sent with every request, on top of the route's own
+	Headers  map[string]string `yaml:"headers"`
+	IDs      IDRange           `yaml:"ids"`
+	Routes   []Route           `yaml:"routes"`
+	Mode     string            `yaml:"mode"`
+	Duration time.Duration     `yaml:"duration"`
+	Rate     RateProfile       `yaml:"rate"`
+	Closed   ClosedLoop        `yaml:"closed"`
+	Search   Search            `yaml:"search"`
+}
+
+// ClosedLoop are virtual users each sending a request, waiting for its reply, then thinking before the next one.
+type ClosedLoop struct {
+	Users int           `yaml:"users"`
+	Think time.Duration `yaml:"think"`
+}
+
+// IDRange are the IDs requested: Count of them from From, in turn or at random.
+type IDRange struct {
+	From   int  `yaml:"from"`
+	Count  int  `yaml:"count"`
+	Random bool `yaml:"random"`
+}
+
+// Route is one kind of request, picked in proportion to its weight.
+type Route struct {
+	Name   string `yaml:"name"`
+	Method string `yaml:"method"`
+	Path   string `yaml:"path"`
+	Body   string `yaml:"body"`
+	// BodyFile is read once as the body, when Body is empty
+	BodyFile string            `yaml:"bodyFile"`
+	Headers  map[string]string `yaml:"headers"`
+	Weight   int               `yaml:"weight"`
+}
+
+func defaultScenario() Scenario {
+	return Scenario{
+		BaseURL:  "http://localhost:8080",
+		IDs:      IDRange{Count: 1000000},
+		Mode:     ModeOpen,
+		Duration: 5 * time.Second,
+		Rate:     RateProfile{Shape: ProfileConstant, Rate: 100},
+		Closed:   ClosedLoop{Users: 10},
+		Search:   defaultSearch(),
+	}
+}
+
+func defaultRoute() Route {
+	return Route{Name: "inquiry", Method: "GET", Path: "/inquiry/" + IDPlaceholder, Weight: 1}
+}
+
+// loadScenario reads a YAML (or JSON) scenario file over the defaults.
+func loadScenario(path string, sc *Scenario) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(b, sc); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// Validate reports the first setting that can't work, filling in route defaults and reading body files.
+func (sc *Scenario) Validate() error {
+	switch {
+	case !strings.HasPrefix(sc.BaseURL, "http://") && !strings.HasPrefix(sc.BaseURL, "https://"):
+		return fmt.Errorf("baseURL must be an http or https URL")
+	case sc.IDs.Count <= 0:
+		return fmt.Errorf("ids.count must be positive")
+	case sc.Duration <= 0 && sc.Mode != ModeSearch:
+		return fmt.Errorf("duration must be positive")
+	}
+	sc.BaseURL = strings.TrimSuffix(sc.BaseURL, "/")
+
+	if len(sc.Routes) == 0 {
+		sc.Routes = []Route{defaultRoute()}
+	}
+	for i := range sc.Routes {
+		r := &sc.Routes[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("route%d", i)
+		}
+		if r.Method == "" {
+			r.Method = "GET"
+		}
+		switch {
+		case !strings.HasPrefix(r.Path, "/"):
+			return fmt.Errorf("routes.%s.path must start with /", r.Name)
+		case r.Weight < 0:
+			return fmt.Errorf("routes.%s.weight can't be negative", r.Name)
+		case r.Body != "" && r.BodyFile != "":
+			return fmt.Errorf("routes.%s has both a body and a bodyFile", r.Name)
+		}
+		if r.Weight == 0 {
+			r.Weight = 1
+		}
+		if r.BodyFile != "" {
+			b, err := ioutil.ReadFile(r.BodyFile)
+			if err != nil {
+				return fmt.Errorf("routes.%s.bodyFile: %v", r.Name, err)
+			}
+			r.Body = string(b)
+		}
+	}
+
+	switch sc.Mode {
+	case ModeOpen:
+		return sc.Rate.validate()
+	case ModeClosed:
+		if sc.Closed.Users <= 0 || sc.Closed.Think < 0 {
+			return fmt.Errorf("closed.users must be positive and closed.think can't be negative")
+		}
+	case ModeSearch:
+		return sc.Search.validate()
+	default:
+		return fmt.Errorf("mode must be open, closed or search")
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// Search looks for the knee point: the highest rate the gateway sustains within the thresholds. It tries
+// rates from Rate up by Step, each for StepFor, then bisects between the last one within the thresholds and the
+// first one beyond them Refine times.
+type Search struct {
+	Rate    float64       `yaml:"rate"`
+	Step    float64       `yaml:"step"`
+	StepFor time.Duration `yaml:"stepFor"`
+	// MaxRate ends the search even within the thresholds, 0 leaving it unbounded
+	MaxRate float64 `yaml:"maxRate"`
+	Refine  int     `yaml:"refine"`
+	// P99 is the highest 99th percentile latency accepted, 0 accepting any
+	P99 time.Duration `yaml:"p99"`
+	// ErrorRate is the highest ratio of failed requests accepted
+	ErrorRate float64 `yaml:"errorRate"`
+}
+
+func defaultSearch() Search {
+	return Search{Rate: 100, Step: 100, StepFor: 10 * time.Second, Refine: 2, P99: time.Second, ErrorRate: 0.01}
+}
+
+func (s *Search) validate() error {
+	switch {
+	case s.Rate <= 0 || s.Step <= 0:
+		return fmt.Errorf("search.rate and search.step must be positive")
+	case s.StepFor <= 0:
+		return fmt.Errorf("search.stepFor must be positive")
+	case s.MaxRate < 0 || s.Refine < 0 || s.P99 < 0:
+		return fmt.Errorf("search.maxRate, search.refine and search.p99 can't be negative")
+	case s.ErrorRate < 0 || s.ErrorRate > 1:
+		return fmt.Errorf("search.errorRate must be between 0 and 1")
+	case s.P99 == 0 && s.ErrorRate == 1 && s.MaxRate == 0:
+		return fmt.Errorf("search needs a search.p99, a search.errorRate below 1 or a search.maxRate to ever end")
+	}
+	return nil
+}
+
+// SearchStep is how the gateway fared at one rate.
+type SearchStep struct {
+	Rate       float64       `json:"rate"`
+	Throughput float64       `json:"throughput"`
+	P99        time.Duration `json:"99th"`
+	ErrorRate  float64       `json:"error_rate"`
+	// Within is whether the step stayed within the thresholds
+	Within bool `json:"within"`
+}
+
+// SearchResult lists the steps in the order they were tried.
+type SearchResult struct {
+	Steps []SearchStep `json:"steps"`
+	// Knee is the highest rate within the thresholds, none when even the lowest one tried crossed them
+	Knee *SearchStep `json:"knee"`
+}
+
+// search runs the steps, handing every result to each and every step to done as they complete.
+func search(a *attacker, s Search, each func(*vegeta.Result), done func(SearchStep)) SearchResult {
+	var result SearchResult
+	try := func(rate float64) SearchStep {
+		rep := newReport(time.Now(), s.StepFor)
+		for res := range attack(a, RateProfile{Shape: ProfileConstant, Rate: rate}, s.StepFor) {
+			rep.add(res)
+			each(res)
+		}
+		sum := rep.summary()
+
+		step := SearchStep{Rate: rate, Throughput: sum.Throughput, P99: sum.Latencies.P99, ErrorRate: 1 - sum.Success}
+		step.Within = (s.P99 == 0 || step.P99 <= s.P99) && step.ErrorRate <= s.ErrorRate
+		result.Steps = append(result.Steps, step)
+		if step.Within && (result.Knee == nil || rate > result.Knee.Rate) {
+			knee := step
+			result.Knee = &knee
+		}
+		done(step)
+		return step
+	}
+
+	beyond := 0.0
+	for rate := s.Rate; s.MaxRate == 0 || rate <= s.MaxRate; rate += s.Step {
+		if !try(rate).Within {
+			beyond = rate
+			break
+		}
+	}
+
+	for i := 0; i < s.Refine && beyond > 0; i++ {
+		within := 0.0
+		if result.Knee != nil {
+			within = result.Knee.Rate
+		}
+		rate := (within + beyond) / 2
+		if !try(rate).Within {
+			beyond = rate
+		}
+	}
+	return result
+}
+
+func (r SearchResult) write(w io.Writer, format string) error {
+	if format == ReportJSON {
+		return json.NewEncoder(w).Encode(r)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "rate\tthroughput\t99th\terrors\t\t")
+	for _, step := range r.Steps {
+		fmt.Fprintln(tw, step.row())
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if r.Knee == nil {
+		_, err := fmt.Fprintln(w, "\nKnee: none, every rate tried crossed the thresholds")
+		return err
+	}
+	crossed := false
+	for _, step := range r.Steps {
+		crossed = crossed || !step.Within
+	}
+	if !crossed {
+		_, err := fmt.Fprintf(w, "\nKnee: not reached, every rate up to %.2f/s stayed within the thresholds\n", r.Knee.Rate)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\nKnee: %.2f/s, throughput %.2f/s, 99th %s, errors %.2f%%\n",
+		r.Knee.Rate, r.Knee.Throughput, r.Knee.P99, r.Knee.ErrorRate*100)
+	return err
+}
+
+func (step SearchStep) verdict() string {
+	if step.Within {
+		return "within"
+	}
+	return "beyond"
+}
+
+func (step SearchStep) row() string {
+	return fmt.Sprintf("%.2f\t%.2f\t%s\t%.2f%%\t%s\t", step.Rate, step.Throughput, step.P99, step.ErrorRate*100, step.verdict())
+}
+
+func (step SearchStep) String() string {
+	return fmt.Sprintf("%.2f/s: throughput %.2f/s, 99th %s, errors %.2f%%, %s the thresholds",
+		step.Rate, step.Throughput, step.P99, step.ErrorRate*100, step.verdict())
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// echoGateway answers every inquiry with its ID, tracking the most requests it has had in flight at once.
+func echoGateway(handle func(w http.ResponseWriter) bool) (*httptest.Server, *int64) {
+	var inFlight, most int64
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		for m := atomic.LoadInt64(&most); n > m && !atomic.CompareAndSwapInt64(&most, m, n); m = atomic.LoadInt64(&most) {
+		}
+
+		if handle != nil && !handle(w) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"ID": %q}`, strings.TrimPrefix(r.URL.Path, "/inquiry/"))
+	})), &most
+}
+
+func testAttacker(t *testing.T, baseURL string) *attacker {
+	sc := defaultScenario()
+	sc.BaseURL = baseURL
+	if err := sc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return newAttacker(newTargeter(&sc), "test")
+}
+
+func TestClosedLoopWaitsForReplies(t *testing.T) {
+	gateway, most := echoGateway(func(http.ResponseWriter) bool {
+		time.Sleep(10 * time.Millisecond)
+		return true
+	})
+	defer gateway.Close()
+
+	requests := 0
+	for res := range closedLoop(testAttacker(t, gateway.URL), ClosedLoop{Users: 5, Think: 40 * time.Millisecond}, 500*time.Millisecond, nil) {
+		if res.Error != "" {
+			t.Fatal(res.Error)
+		}
+		requests++
+	}
+
+	// Each user sends a request every 50ms at best
+	if requests < 25 || requests > 55 {
+		t.Errorf("%d requests from 5 users over 500ms", requests)
+	}
+	if *most > 5 {
+		t.Errorf("%d requests in flight at once from 5 users", *most)
+	}
+}
+
+func TestClosedLoopEndsOnTime(t *testing.T) {
+	gateway, _ := echoGateway(nil)
+	defer gateway.Close()
+
+	// A second think would take the users past the end of the run
+	var warnings []string
+	start := time.Now()
+	for range closedLoop(testAttacker(t, gateway.URL), ClosedLoop{Users: 2, Think: 200 * time.Millisecond}, 300*time.Millisecond,
+		func(msg string) { warnings = append(warnings, msg) }) {
+	}
+
+	if took := time.Since(start); took > 300*time.Millisecond {
+		t.Errorf("a 300ms closed loop took %s", took)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("warnings %q with replies quicker than the think time", warnings)
+	}
+}
+
+func TestClosedLoopWarnsWhenTheGatewayFallsBehind(t *testing.T) {
+	gateway, _ := echoGateway(func(http.ResponseWriter) bool {
+		time.Sleep(50 * time.Millisecond)
+		return true
+	})
+	defer gateway.Close()
+
+	var warnings []string
+	for range closedLoop(testAttacker(t, gateway.URL), ClosedLoop{Users: 2, Think: 20 * time.Millisecond}, 200*time.Millisecond,
+		func(msg string) { warnings = append(warnings, msg) }) {
+	}
+
+	if len(warnings) != 1 {
+		t.Errorf("warnings %q, want one about replies taking longer than the think time", warnings)
+	}
+}
+
+func TestSearchFindsTheKnee(t *testing.T) {
+	// The gateway turns away what goes beyond 80 requests every 500ms
+	var mu sync.Mutex
+	var windowStart time.Time
+	var inWindow int
+	gateway, _ := echoGateway(func(w http.ResponseWriter) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if now := time.Now(); now.Sub(windowStart) >= 500*time.Millisecond {
+			windowStart, inWindow = now, 0
+		}
+		if inWindow++; inWindow > 80 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return false
+		}
+		return true
+	})
+	defer gateway.Close()
+
+	s := Search{Rate: 50, Step: 50, StepFor: 500 * time.Millisecond, Refine: 2, ErrorRate: 0.01}
+	var steps []SearchStep
+	result := search(testAttacker(t, gateway.URL), s, func(*vegeta.Result) {}, func(step SearchStep) { steps = append(steps, step) })
+
+	var rates []float64
+	for _, step := range result.Steps {
+		rates = append(rates, step.Rate)
+	}
+	if want := []float64{50, 100, 150, 200, 175, 162.5}; fmt.Sprint(rates) != fmt.Sprint(want) {
+		t.Errorf("tried %v, want %v", rates, want)
+	}
+	if len(steps) != len(result.Steps) {
+		t.Errorf("%d steps reported as they completed, %d tried", len(steps), len(result.Steps))
+	}
+	if result.Knee == nil || result.Knee.Rate != 150 || result.Knee.ErrorRate != 0 {
+		t.Fatalf("knee %+v", result.Knee)
+	}
+
+	var out bytes.Buffer
+	if err := result.write(&out, ReportText); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Knee: 150.00/s") {
+		t.Errorf("text:\n%s", out.String())
+	}
+}
+
+func TestSearchWithoutKnee(t *testing.T) {
+	gateway, _ := echoGateway(func(w http.ResponseWriter) bool {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+		return false
+	})
+	defer gateway.Close()
+
+	s := Search{Rate: 20, Step: 20, StepFor: 200 * time.Millisecond, Refine: 1, ErrorRate: 0.5}
+	result := search(testAttacker(t, gateway.URL), s, func(*vegeta.Result) {}, func(SearchStep) {})
+	if len(result.Steps) != 2 || result.Knee != nil {
+		t.Errorf("steps %+v, knee %+v", result.Steps, result.Knee)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// targeter builds each target when it's about to be sent, rather than holding every one of them in memory.
+type targeter struct {
+	baseURL string
+	ids     IDRange
+	routes  []Route
+	// schedule lists route indexes, each as many times as its weight, and is walked in turn
+	schedule []int
+	headers  http.Header
+
+	issued uint64
+	mu     sync.Mutex
+	rng    *rand.Rand
+}
+
+func newTargeter(sc *Scenario) *targeter {
+	t := &targeter{
+		baseURL: sc.BaseURL,
+		ids:     sc.IDs,
+		routes:  sc.Routes,
+		headers: http.Header{},
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for i, r := range sc.Routes {
+		for w := 0; w < r.Weight; w++ {
+			t.schedule = append(t.schedule, i)
+		}
+	}
+	for k, v := range sc.Headers {
+		t.headers.Set(k, v)
+	}
+	return t
+}
+
+// next fills the target in, returning the inquiry ID its reply must carry, none when the route doesn't ask for one.
+// It's safe for concurrent use.
+func (t *targeter) next(tgt *vegeta.Target) string {
+	n := atomic.AddUint64(&t.issued, 1) - 1
+	route := t.routes[t.schedule[n%uint64(len(t.schedule))]]
+	id := t.id(n)
+
+	tgt.Method = route.Method
+	tgt.URL = t.baseURL + strings.Replace(route.Path, IDPlaceholder, id, -1)
+	tgt.Body = nil
+	if route.Body != "" {
+		tgt.Body = []byte(strings.Replace(route.Body, IDPlaceholder, id, -1))
+	}
+	tgt.Header = http.Header{}
+	for k, vs := range t.headers {
+		tgt.Header[k] = vs
+	}
+	for k, v := range route.Headers {
+		tgt.Header.Set(k, strings.Replace(v, IDPlaceholder, id, -1))
+	}
+
+	if !strings.Contains(route.Path, IDPlaceholder) && !strings.Contains(route.Body, IDPlaceholder) {
+		return ""
+	}
+	return id
+}
+
+func (t *targeter) id(n uint64) string {
+	offset := int(n % uint64(t.ids.Count))
+	if t.ids.Random {
+		t.mu.Lock()
+		offset = t.rng.Intn(t.ids.Count)
+		t.mu.Unlock()
+	}
+	return strconv.Itoa(t.ids.From + offset)
+}
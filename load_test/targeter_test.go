@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+func TestTargeterFollowsTheScenario(t *testing.T) {
+	sc := defaultScenario()
+	sc.BaseURL = "http://gateway:9090/"
+	sc.Headers = map[string]string{"X-Load-Test": "true"}
+	sc.IDs = IDRange{From: 100, Count: 3}
+	sc.Routes = []Route{
+		{Name: "get", Path: "/inquiry/{id}", Weight: 2},
+		{Name: "post", Method: "POST", Path: "/inquiry", Body: `{"id": "{id}"}`, Headers: map[string]string{"X-Request-ID": "load-{id}"}},
+	}
+	if err := sc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := newTargeter(&sc)
+	want := []struct{ id, method, url, body, requestID string }{
+		{"100", "GET", "http://gateway:9090/inquiry/100", "", ""},
+		{"101", "GET", "http://gateway:9090/inquiry/101", "", ""},
+		{"102", "POST", "http://gateway:9090/inquiry", `{"id": "102"}`, "load-102"},
+		{"100", "GET", "http://gateway:9090/inquiry/100", "", ""},
+	}
+	for i, w := range want {
+		var tgt vegeta.Target
+		id := tr.next(&tgt)
+		if id != w.id {
+			t.Errorf("target %d: ID %s, want %s", i, id, w.id)
+		}
+		if tgt.Method != w.method || tgt.URL != w.url || string(tgt.Body) != w.body || tgt.Header.Get("X-Request-ID") != w.requestID {
+			t.Errorf("target %d: %s %s %q %v, want %s %s %q X-Request-ID %q", i, tgt.Method, tgt.URL, tgt.Body, tgt.Header, w.method, w.url, w.body, w.requestID)
+		}
+		if tgt.Header.Get("X-Load-Test") != "true" {
+			t.Errorf("target %d: scenario header missing", i)
+		}
+	}
+}
+
+func TestTargeterRandomIDsStayInRange(t *testing.T) {
+	sc := defaultScenario()
+	sc.IDs = IDRange{From: 10, Count: 5, Random: true}
+	if err := sc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := newTargeter(&sc)
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		var tgt vegeta.Target
+		tr.next(&tgt)
+		seen[tgt.URL] = true
+	}
+	for id := 10; id < 15; id++ {
+		delete(seen, sc.BaseURL+"/inquiry/"+strconv.Itoa(id))
+	}
+	if len(seen) != 0 {
+		t.Errorf("IDs out of range: %v", seen)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/go-redis/redis"
+)
+
+// kafkaProducer is what the http server needs of a *kafka.Producer, tests use an in-memory broker instead.
+type kafkaProducer interface {
+	Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error
+	Close()
+}
+
+// kafkaConsumer is what the consumer needs of a *kafka.Consumer.
+type kafkaConsumer interface {
+	SubscribeTopics(topics []string, rebalanceCb kafka.RebalanceCb) error
+	ReadMessage(timeout time.Duration) (*kafka.Message, error)
+	Close() error
+}
+
+// redisClient is what both sub commands need of a redis.UniversalClient.
+type redisClient interface {
+	Ping() *redis.StatusCmd
+	Get(key string) *redis.StringCmd
+	Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd
+	Pipeline() redis.Pipeliner
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/sony/gobreaker"
+)
+
+// tripAfterTwo opens a breaker once two inquiries failed, for a second.
+func tripAfterTwo(c *Config) {
+	c.Breaker.MinRequests = 2
+	c.Breaker.OpenTimeout = time.Second
+}
+
+func TestBreakersFailFast(t *testing.T) {
+	cases := []struct {
+		name    string
+		fail    func(f *testFlow)
+		breaker func() *breaker
+		status  int
+	}{
+		{
+			name:    "kafka failing",
+			fail:    func(f *testFlow) { f.broker.FailProduce(errors.New("queue full")) },
+			breaker: func() *breaker { return publishBreaker },
+			status:  http.StatusServiceUnavailable,
+		},
+		{
+			name:    "no response in redis",
+			fail:    func(f *testFlow) { f.stopConsumer() },
+			breaker: func() *breaker { return responseBreaker },
+			status:  http.StatusGatewayTimeout,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := startFlow(t, tripAfterTwo)
+			defer f.close()
+			tc.fail(f)
+
+			for i := 0; i < 2; i++ {
+				if resp, body := f.inquire(t, "42"); resp.StatusCode != tc.status {
+					t.Fatalf("status = %d, want %d, body %s", resp.StatusCode, tc.status, body)
+				}
+			}
+			if state := tc.breaker().state(); state != gobreaker.StateOpen {
+				t.Fatalf("breaker is %s after two failures", state)
+			}
+
+			base := f.produced()
+			start := time.Now()
+			resp, body := f.inquire(t, "42")
+			if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "1" {
+				t.Errorf("status = %d, Retry-After %q with the breaker open, body %s", resp.StatusCode, resp.Header.Get("Retry-After"), body)
+			}
+			if took := time.Since(start); took > 100*time.Millisecond {
+				t.Errorf("took %s with the breaker open", took)
+			}
+			if produced := f.produced() - base; produced != 0 {
+				t.Errorf("%d messages produced with the breaker open", produced)
+			}
+		})
+	}
+}
+
+// lateProducer reports deliveries only after a while, like a broker that's slow to acknowledge.
+type lateProducer struct {
+	after time.Duration
+}
+
+func (p lateProducer) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+	go func() {
+		time.Sleep(p.after)
+		deliveryChan <- msg
+	}()
+	return nil
+}
+
+func (p lateProducer) Close() {}
+
+func TestProduceTimeout(t *testing.T) {
+	f := startFlow(t, func(c *Config) { c.Kafka.ProduceTimeout = 50 * time.Millisecond })
+	defer f.close()
+	producer = lateProducer{after: 300 * time.Millisecond}
+
+	start := time.Now()
+	resp, body := f.inquire(t, "42")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503, body %s", resp.StatusCode, body)
+	}
+	if took := time.Since(start); took < 50*time.Millisecond || took > 250*time.Millisecond {
+		t.Errorf("took %s, want about kafka.produceTimeout", took)
+	}
+
+	// The late report lands in the abandoned channel, sending on a closed one would panic
+	time.Sleep(300 * time.Millisecond)
+}
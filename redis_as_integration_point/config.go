@@ -0,0 +1,230 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/amura2406/inquiry-kafka-redis-poc/internal/config"
+)
+
+// Config holds every setting of both sub commands. It's layered from lowest to highest precedence:
+// built-in defaults, the config file, INQUIRY_* environment variables and finally command line flags.
+type Config struct {
+	Kafka    KafkaConfig    `config:"kafka"`
+	Redis    RedisConfig    `config:"redis"`
+	Inquiry  InquiryConfig  `config:"inquiry"`
+	HTTP     HTTPConfig     `config:"http"`
+	Consumer ConsumerConfig `config:"consumer"`
+	Cache    CacheConfig    `config:"cache"`
+	Breaker  BreakerConfig  `config:"breaker"`
+}
+
+type KafkaConfig struct {
+	Broker        string `config:"broker"`
+	Topic         string `config:"topic"`
+	ConsumerGroup string `config:"consumerGroup"`
+	// ProduceTimeout bounds the wait for the broker to acknowledge an inquiry
+	ProduceTimeout time.Duration `config:"produceTimeout"`
+	// Properties are handed to librdkafka as is, on both the producer and the consumer
+	Properties map[string]string `config:"properties"`
+	// Producer and Consumer properties only apply to their role, taking precedence over Properties
+	Producer map[string]string `config:"producer"`
+	Consumer map[string]string `config:"consumer"`
+}
+
+type RedisConfig struct {
+	// Mode is single, cluster or sentinel
+	Mode string `config:"mode"`
+	// Address is comma separated: the node, the cluster seed nodes or the sentinels depending on the mode
+	Address string `config:"address"`
+	// MasterName is the primary monitored by the sentinels
+	MasterName string          `config:"masterName"`
+	Password   string          `config:"password" secret:"true"`
+	DB         int             `config:"db"`
+	HTTP       RedisPoolConfig `config:"http"`
+	Consumer   RedisPoolConfig `config:"consumer"`
+}
+
+type RedisPoolConfig struct {
+	PoolSize     int           `config:"poolSize"`
+	MinIdleConns int           `config:"minIdleConns"`
+	PoolTimeout  time.Duration `config:"poolTimeout"`
+}
+
+type InquiryConfig struct {
+	// PollInterval is the wait between polls of redis, the inquiry timing out after MaxTryCount of them
+	PollInterval time.Duration `config:"pollInterval"`
+}
+
+type HTTPConfig struct {
+	Address           string        `config:"address"`
+	TLSCert           string        `config:"tlsCert"`
+	TLSKey            string        `config:"tlsKey"`
+	TLSClientCA       string        `config:"tlsClientCA"`
+	ReadHeaderTimeout time.Duration `config:"readHeaderTimeout"`
+	WriteTimeout      time.Duration `config:"writeTimeout"`
+	IdleTimeout       time.Duration `config:"idleTimeout"`
+	MaxHeaderBytes    int           `config:"maxHeaderBytes"`
+	H2C               bool          `config:"h2c"`
+}
+
+type ConsumerConfig struct {
+	Async bool `config:"async"`
+	// DelayMin and DelayMax bound the uniform synthetic delay, which is DelayMin when DelayMax isn't above it
+	DelayMin time.Duration `config:"delayMin"`
+	DelayMax time.Duration `config:"delayMax"`
+}
+
+type CacheConfig struct {
+	// Freshness serves the stored response of a previous inquiry for the same ID while younger than this,
+	// 0 always asks the consumer
+	Freshness time.Duration `config:"freshness"`
+	// Coalesce lets concurrent requests for the same ID share one kafka message
+	Coalesce bool `config:"coalesce"`
+}
+
+// BreakerConfig applies to both the kafka publish and the redis response circuit breakers.
+type BreakerConfig struct {
+	Enabled bool `config:"enabled"`
+	// FailureRatio of the requests counted within Interval trips the breaker, once there are MinRequests of them
+	FailureRatio float64       `config:"failureRatio"`
+	MinRequests  int           `config:"minRequests"`
+	Interval     time.Duration `config:"interval"`
+	// OpenTimeout is how long the breaker fails fast before letting HalfOpenRequests probes through
+	OpenTimeout      time.Duration `config:"openTimeout"`
+	HalfOpenRequests int           `config:"halfOpenRequests"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Kafka: KafkaConfig{
+			Broker:         "localhost",
+			Topic:          "poc-test",
+			ConsumerGroup:  "testCG",
+			ProduceTimeout: 5 * time.Second,
+			Properties:     map[string]string{},
+			Producer:       map[string]string{},
+			Consumer:       map[string]string{},
+		},
+		Redis: RedisConfig{
+			Mode:    RedisModeSingle,
+			Address: "localhost:6379",
+			HTTP: RedisPoolConfig{
+				PoolSize:     10000,
+				MinIdleConns: 100,
+				PoolTimeout:  1 * time.Second,
+			},
+			Consumer: RedisPoolConfig{
+				PoolSize:     50,
+				MinIdleConns: 10,
+				PoolTimeout:  1 * time.Second,
+			},
+		},
+		Inquiry: InquiryConfig{
+			PollInterval: 500 * time.Millisecond,
+		},
+		HTTP: HTTPConfig{
+			Address:           ":8080",
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      15 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			MaxHeaderBytes:    1 << 20,
+		},
+		Consumer: ConsumerConfig{
+			Async: true,
+		},
+		Cache: CacheConfig{
+			Coalesce: true,
+		},
+		Breaker: BreakerConfig{
+			Enabled:          true,
+			FailureRatio:     0.5,
+			MinRequests:      20,
+			Interval:         10 * time.Second,
+			OpenTimeout:      5 * time.Second,
+			HalfOpenRequests: 5,
+		},
+	}
+}
+
+// loadConfig parses the sub command flags and layers the config file and environment underneath them.
+func loadConfig(fs *flag.FlagSet, args []string) error {
+	if err := config.Load(&cfg, fs, args, &configFile); err != nil {
+		return err
+	}
+	return cfg.Validate()
+}
+
+// Validate reports the first setting that can't work.
+func (c *Config) Validate() error {
+	switch {
+	case c.Kafka.Broker == "":
+		return fmt.Errorf("kafka.broker is required")
+	case c.Kafka.Topic == "":
+		return fmt.Errorf("kafka.topic is required")
+	case c.Kafka.ConsumerGroup == "":
+		return fmt.Errorf("kafka.consumerGroup is required")
+	case c.Kafka.ProduceTimeout <= 0:
+		return fmt.Errorf("kafka.produceTimeout must be positive")
+	case c.Redis.Mode != RedisModeSingle && c.Redis.Mode != RedisModeCluster && c.Redis.Mode != RedisModeSentinel:
+		return fmt.Errorf("redis.mode must be single, cluster or sentinel")
+	case c.Redis.Address == "":
+		return fmt.Errorf("redis.address is required")
+	case c.Redis.Mode == RedisModeSentinel && c.Redis.MasterName == "":
+		return fmt.Errorf("redis.masterName is required in sentinel mode")
+	case c.Redis.Mode == RedisModeCluster && c.Redis.DB != 0:
+		return fmt.Errorf("redis.db must be 0 in cluster mode")
+	case c.Redis.DB < 0:
+		return fmt.Errorf("redis.db can't be negative")
+	case c.Inquiry.PollInterval <= 0:
+		return fmt.Errorf("inquiry.pollInterval must be positive")
+	case c.HTTP.Address == "":
+		return fmt.Errorf("http.address is required")
+	case (c.HTTP.TLSCert == "") != (c.HTTP.TLSKey == ""):
+		return fmt.Errorf("http.tlsCert and http.tlsKey must be set together")
+	case c.HTTP.TLSClientCA != "" && c.HTTP.TLSCert == "":
+		return fmt.Errorf("http.tlsClientCA requires http.tlsCert and http.tlsKey")
+	case c.HTTP.ReadHeaderTimeout < 0 || c.HTTP.WriteTimeout < 0 || c.HTTP.IdleTimeout < 0:
+		return fmt.Errorf("http timeouts can't be negative")
+	case c.HTTP.MaxHeaderBytes <= 0:
+		return fmt.Errorf("http.maxHeaderBytes must be positive")
+	case c.Consumer.DelayMin < 0 || c.Consumer.DelayMax < 0:
+		return fmt.Errorf("consumer delays can't be negative")
+	case c.Cache.Freshness < 0 || c.Cache.Freshness > ResultTTL:
+		return fmt.Errorf("cache.freshness must be between 0 and %s, the lifetime of stored responses", ResultTTL)
+	case c.Breaker.FailureRatio <= 0 || c.Breaker.FailureRatio > 1:
+		return fmt.Errorf("breaker.failureRatio must be above 0 and at most 1")
+	case c.Breaker.MinRequests <= 0:
+		return fmt.Errorf("breaker.minRequests must be positive")
+	case c.Breaker.Interval < 0:
+		return fmt.Errorf("breaker.interval can't be negative")
+	case c.Breaker.OpenTimeout <= 0:
+		return fmt.Errorf("breaker.openTimeout must be positive")
+	case c.Breaker.HalfOpenRequests <= 0:
+		return fmt.Errorf("breaker.halfOpenRequests must be positive")
+	}
+
+	for name, pool := range map[string]RedisPoolConfig{"http": c.Redis.HTTP, "consumer": c.Redis.Consumer} {
+		if pool.PoolSize <= 0 || pool.MinIdleConns < 0 || pool.PoolTimeout <= 0 {
+			return fmt.Errorf("redis.%s pool settings must be positive", name)
+		}
+	}
+	return nil
+}
+
+func runConfigCommand(action string) {
+	switch action {
+	case "validate":
+		fmt.Println("Configuration is valid")
+	case "dump":
+		if err := config.Dump(&cfg, os.Stdout, dumpFormat); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Println("validate or dump config action is required !")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/amura2406/inquiry-kafka-redis-poc/internal/config"
+)
+
+// TestExampleConfig keeps config.example.yaml in line with the defaults it claims to show.
+func TestExampleConfig(t *testing.T) {
+	got := defaultConfig()
+	if err := config.LoadFile(&got, "config.example.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, defaultConfig()) {
+		t.Errorf("config.example.yaml loads %+v, want the defaults %+v", got, defaultConfig())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for name, configure := range map[string]func(c *Config){
+		"sentinel without master": func(c *Config) { c.Redis.Mode = RedisModeSentinel },
+		"database in cluster":     func(c *Config) { c.Redis.Mode = RedisModeCluster; c.Redis.DB = 1 },
+		"freshness past the TTL":  func(c *Config) { c.Cache.Freshness = ResultTTL + 1 },
+		"no poll interval":        func(c *Config) { c.Inquiry.PollInterval = 0 },
+		"empty redis pool":        func(c *Config) { c.Redis.Consumer.PoolSize = 0 },
+	} {
+		c := defaultConfig()
+		configure(&c)
+		if err := c.Validate(); err == nil {
+			t.Errorf("%s is valid", name)
+		}
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	c := defaultConfig()
+	err := config.LoadEnv(&c, []string{
+		"INQUIRY_REDIS_HTTP_POOL_SIZE=7",
+		"INQUIRY_INQUIRY_POLL_INTERVAL=20ms",
+		"INQUIRY_KAFKA_PRODUCER_LINGER_MS=5",
+		"INQUIRY_KAFKA_CONSUMER_GROUP=inquiries",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Redis.HTTP.PoolSize != 7 || c.Inquiry.PollInterval.String() != "20ms" {
+		t.Errorf("loaded redis.http.poolSize %d and inquiry.pollInterval %s", c.Redis.HTTP.PoolSize, c.Inquiry.PollInterval)
+	}
+	if c.Kafka.Producer["linger.ms"] != "5" {
+		t.Errorf("kafka.producer = %v, want linger.ms 5", c.Kafka.Producer)
+	}
+	if c.Kafka.ConsumerGroup != "inquiries" || len(c.Kafka.Consumer) != 0 {
+		t.Errorf("loaded kafka.consumerGroup %q and kafka.consumer %v", c.Kafka.ConsumerGroup, c.Kafka.Consumer)
+	}
+}
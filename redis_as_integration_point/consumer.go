@@ -2,38 +2,44 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/bxcodec/faker"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
-	"github.com/go-redis/redis"
 	log "github.com/sirupsen/logrus"
 )
 
 var (
-	consumer *kafka.Consumer
+	consumer kafkaConsumer
+	// random draws the synthetic delays, guarded by randomMu as messages may be processed concurrently
 	random   *rand.Rand
+	randomMu sync.Mutex
 )
 
 func StartConsumer() {
-	redisOpts := &redis.Options{
-		Addr:         redisAddress,
-		Password:     "", // no password set
-		DB:           0,  // use default DB
-		PoolSize:     50,
-		MinIdleConns: 10,
-		PoolTimeout:  1 * time.Second,
-	}
-
 	initRandom()
 	initConsumer()
-	initRedis(redisOpts)
+	initRedis(cfg.Redis.Consumer)
 
 	log.Infoln("Listening now...")
+	consume(consumer, nil)
+}
+
+// consume processes the messages read by c until done is closed, closing c and waiting for the messages
+// still being processed.
+func consume(c kafkaConsumer, done <-chan struct{}) {
+	go func() {
+		<-done
+		c.Close()
+	}()
+
+	var inFlight sync.WaitGroup
+	defer inFlight.Wait()
+
 	for {
-		msg, err := consumer.ReadMessage(-1)
+		msg, err := c.ReadMessage(-1)
 		if err == nil {
 			var metas map[string]interface{}
 			metaBytes, err := json.Marshal(msg.TopicPartition)
@@ -41,37 +47,61 @@ func StartConsumer() {
 				panic(err)
 			}
 			json.Unmarshal(metaBytes, &metas)
-			if asyncConsume {
-				go processMessage(msg)
+			if cfg.Consumer.Async {
+				inFlight.Add(1)
+				go func() {
+					defer inFlight.Done()
+					processMessage(msg)
+				}()
 			} else {
 				processMessage(msg)
 			}
+		} else if isDone(done) {
+			return
 		} else {
 			// The client will automatically try to recover from all errors.
 			log.Errorf("Consumer error: %v (%v)\n", err, msg)
 		}
 	}
+}
 
-	consumer.Close()
+func isDone(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
 }
 
 func initRandom() {
 	random = rand.New(rand.NewSource(time.Now().UnixNano()))
 }
 
+// randomDelay is uniform between consumer.delayMin and consumer.delayMax.
+func randomDelay() time.Duration {
+	delta := cfg.Consumer.DelayMin
+	if Δ := int64(cfg.Consumer.DelayMax) - int64(cfg.Consumer.DelayMin); Δ > 0 {
+		randomMu.Lock()
+		delta += time.Duration(random.Int63n(Δ))
+		randomMu.Unlock()
+	}
+	return delta
+}
+
 func initConsumer() {
 	log.Infoln("Consumer starting...")
-	c, err := kafka.NewConsumer(&kafka.ConfigMap{
-		"bootstrap.servers": broker,
-		"group.id":          consumerGroup,
+	c, err := kafka.NewConsumer(kafkaConfig("consumer", cfg.Kafka.Consumer, kafka.ConfigMap{
+		"bootstrap.servers": cfg.Kafka.Broker,
+		"group.id":          cfg.Kafka.ConsumerGroup,
 		"auto.offset.reset": "latest",
-	})
+	}))
 
 	if err != nil {
 		panic(err)
 	}
 
-	c.SubscribeTopics([]string{topic}, nil)
+	c.SubscribeTopics([]string{cfg.Kafka.Topic}, nil)
 
 	consumer = c
 }
@@ -92,20 +122,20 @@ func processMessage(msg *kafka.Message) {
 	resMsg.Name = reqMsg.Name
 	resMsg.Date = reqMsg.Date
 
+	if delta := randomDelay(); delta > 0 {
+		log.WithField("Δ", delta).Infof("Delay...")
+		time.Sleep(delta)
+	}
+
+	// Stamped once ready, a response to an earlier message that's stored last mustn't look older than
+	// the inquiries already waiting on the key
+	resMsg.Timestamp = time.Now()
 	resBytes, err := json.Marshal(resMsg)
 	if err != nil {
 		panic(err)
 	}
 
-	Δ := int64(delayMax) - int64(delayMin)
-	if Δ > 0 {
-		randΔ := rand.Int63n(Δ)
-		delta := time.Duration(int64(delayMin) + randΔ)
-		log.WithField("Δ", delta).Infof("Delay...")
-		time.Sleep(delta)
-	}
-
-	err = redisCli.Set(fmt.Sprintf("id:%s", reqMsg.ID), resBytes, 10*time.Second).Err()
+	err = redisCli.Set(resultKey(reqMsg.ID), resBytes, ResultTTL).Err()
 	if err != nil {
 		log.Errorf("%v\n", err)
 		return
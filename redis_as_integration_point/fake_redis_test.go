@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// fakeRedis is an in-memory redis of string keys expiring after their TTL.
+type fakeRedis struct {
+	mu   sync.Mutex
+	data map[string]fakeEntry
+	// err fails every command when set, as an unreachable redis would
+	err error
+}
+
+type fakeEntry struct {
+	value   string
+	expires time.Time
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{data: map[string]fakeEntry{}}
+}
+
+// fail makes every command fail with err, until called again with nil.
+func (r *fakeRedis) fail(err error) {
+	r.mu.Lock()
+	r.err = err
+	r.mu.Unlock()
+}
+
+// getLocked reads a key, forgetting it once expired.
+func (r *fakeRedis) getLocked(key string) (fakeEntry, bool) {
+	e, ok := r.data[key]
+	if ok && !e.expires.IsZero() && !time.Now().Before(e.expires) {
+		delete(r.data, key)
+		return fakeEntry{}, false
+	}
+	return e, ok
+}
+
+func (r *fakeRedis) Ping() *redis.StatusCmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return redis.NewStatusResult("", r.err)
+	}
+	return redis.NewStatusResult("PONG", nil)
+}
+
+func (r *fakeRedis) Get(key string) *redis.StringCmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return redis.NewStringResult("", r.err)
+	}
+	e, ok := r.getLocked(key)
+	if !ok {
+		return redis.NewStringResult("", redis.Nil)
+	}
+	return redis.NewStringResult(e.value, nil)
+}
+
+func (r *fakeRedis) Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return redis.NewStatusResult("", r.err)
+	}
+
+	e := fakeEntry{value: fakeString(value)}
+	if expiration > 0 {
+		e.expires = time.Now().Add(expiration)
+	}
+	r.data[key] = e
+	return redis.NewStatusResult("OK", nil)
+}
+
+// PTTL answers -2ms for a missing key and -1ms for one without expiry, as go-redis reads redis' replies.
+func (r *fakeRedis) PTTL(key string) *redis.DurationCmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return redis.NewDurationResult(0, r.err)
+	}
+	e, ok := r.getLocked(key)
+	switch {
+	case !ok:
+		return redis.NewDurationResult(-2*time.Millisecond, nil)
+	case e.expires.IsZero():
+		return redis.NewDurationResult(-time.Millisecond, nil)
+	}
+	return redis.NewDurationResult(time.Until(e.expires).Truncate(time.Millisecond), nil)
+}
+
+// Pipeline runs GET and PTTL right away, Exec reporting the first error like go-redis does.
+func (r *fakeRedis) Pipeline() redis.Pipeliner {
+	return &fakePipeline{r: r}
+}
+
+// fakePipeline only implements the commands in use, the embedded nil Pipeliner panics on anything else.
+type fakePipeline struct {
+	redis.Pipeliner
+	r    *fakeRedis
+	cmds []redis.Cmder
+}
+
+func (p *fakePipeline) Get(key string) *redis.StringCmd {
+	cmd := p.r.Get(key)
+	p.cmds = append(p.cmds, cmd)
+	return cmd
+}
+
+func (p *fakePipeline) PTTL(key string) *redis.DurationCmd {
+	cmd := p.r.PTTL(key)
+	p.cmds = append(p.cmds, cmd)
+	return cmd
+}
+
+func (p *fakePipeline) Exec() ([]redis.Cmder, error) {
+	cmds := p.cmds
+	p.cmds = nil
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil {
+			return cmds, err
+		}
+	}
+	return cmds, nil
+}
+
+func (p *fakePipeline) Close() error {
+	p.cmds = nil
+	return nil
+}
+
+func fakeString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
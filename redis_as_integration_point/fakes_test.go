@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func TestFakeRedisExpiry(t *testing.T) {
+	r := newFakeRedis()
+	r.Set("k", []byte("v"), 20*time.Millisecond)
+	if v, err := r.Get("k").Result(); err != nil || v != "v" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+	if ttl := r.PTTL("k").Val(); ttl <= 0 || ttl > 20*time.Millisecond {
+		t.Errorf("PTTL = %s", ttl)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, err := r.Get("k").Result(); err != redis.Nil {
+		t.Errorf("Get after expiry: %v, want redis.Nil", err)
+	}
+	if ttl := r.PTTL("k").Val(); ttl != -2*time.Millisecond {
+		t.Errorf("PTTL after expiry = %s", ttl)
+	}
+}
+
+func TestFakeRedisPipeline(t *testing.T) {
+	r := newFakeRedis()
+	r.Set("k", "v", time.Second)
+
+	pipe := r.Pipeline()
+	get := pipe.Get("k")
+	ttl := pipe.PTTL("k")
+	if _, err := pipe.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if get.Val() != "v" || ttl.Val() <= 0 {
+		t.Errorf("GET %q, PTTL %s", get.Val(), ttl.Val())
+	}
+
+	pipe.Get("missing")
+	if _, err := pipe.Exec(); err != redis.Nil {
+		t.Errorf("Exec with a missing key: %v, want redis.Nil", err)
+	}
+
+	r.fail(errors.New("redis down"))
+	if err := r.Ping().Err(); err == nil {
+		t.Error("ping succeeded while failing")
+	}
+}
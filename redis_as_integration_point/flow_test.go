@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amura2406/inquiry-kafka-redis-poc/internal/kafkatest"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	log "github.com/sirupsen/logrus"
+)
+
+// FakePartitions is the number of partitions of the fake topics
+const FakePartitions = 3
+
+// testFlow runs the http server and the consumer in-process, talking through a fake kafka and redis.
+type testFlow struct {
+	broker *kafkatest.Broker
+	redis  *fakeRedis
+	server *httptest.Server
+
+	consumerDone    chan struct{}
+	consumerStopped chan struct{}
+}
+
+// startFlow starts the flow with the default configuration, adjusted by configure when given. The consumer
+// answers right away unless configured otherwise, and redis is polled every 10ms to keep timeouts short.
+func startFlow(t *testing.T, configure func(c *Config)) *testFlow {
+	t.Helper()
+	log.SetLevel(log.WarnLevel)
+
+	cfg = defaultConfig()
+	cfg.Inquiry.PollInterval = 10 * time.Millisecond
+	if configure != nil {
+		configure(&cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	initRandom()
+	initBreakers()
+
+	f := &testFlow{broker: kafkatest.NewBroker(FakePartitions), redis: newFakeRedis()}
+	redisCli = f.redis
+	producer = f.broker.NewProducer()
+	f.startConsumer(t)
+	f.server = httptest.NewServer(newRouter())
+	return f
+}
+
+// startConsumer joins a new consumer to the group, resuming from the group's committed offsets.
+func (f *testFlow) startConsumer(t *testing.T) {
+	t.Helper()
+	c := f.broker.NewConsumer(cfg.Kafka.ConsumerGroup, kafka.OffsetEnd)
+	if err := c.SubscribeTopics([]string{cfg.Kafka.Topic}, nil); err != nil {
+		t.Fatal(err)
+	}
+	consumer = c
+	f.consumerDone = make(chan struct{})
+	f.consumerStopped = make(chan struct{})
+	go func(done, stopped chan struct{}) {
+		consume(c, done)
+		close(stopped)
+	}(f.consumerDone, f.consumerStopped)
+}
+
+// stopConsumer stops reading, returning once the messages being processed got their response.
+func (f *testFlow) stopConsumer() {
+	if f.consumerDone != nil {
+		close(f.consumerDone)
+		<-f.consumerStopped
+		f.consumerDone = nil
+	}
+}
+
+func (f *testFlow) close() {
+	f.server.Close()
+	f.stopConsumer()
+}
+
+func (f *testFlow) waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// inquire gets /inquiry/{id}, returning the response with its body read.
+func (f *testFlow) inquire(t *testing.T, id string) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.Get(f.server.URL + "/inquiry/" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestFlowRequestReply(t *testing.T) {
+	f := startFlow(t, nil)
+	defer f.close()
+
+	start := time.Now()
+	resp, body := f.inquire(t, "42")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+	}
+	var res ResponseMessage
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatalf("%v, body %s", err, body)
+	}
+	if res.ID != "42" {
+		t.Errorf("response = %+v, want the one of 42", res)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("took %s, the first poll should have found it", took)
+	}
+
+	// The consumer stored the response for ResultTTL
+	if ttl := f.redis.PTTL(resultKey("42")).Val(); ttl <= 0 || ttl > ResultTTL {
+		t.Errorf("response kept for %s", ttl)
+	}
+}
+
+func TestFlowUndecodableResponse(t *testing.T) {
+	f := startFlow(t, nil)
+	defer f.close()
+	f.stopConsumer()
+	f.redis.Set(resultKey("42"), "not json", ResultTTL)
+
+	// The second inquiry would hang on the coalesced key if the first one had not released it
+	for i := 0; i < 2; i++ {
+		resp, body := f.inquire(t, "42")
+		if resp.StatusCode != http.StatusBadGateway {
+			t.Fatalf("status = %d, want %d, body %s", resp.StatusCode, http.StatusBadGateway, body)
+		}
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/bxcodec/faker"
@@ -11,6 +13,7 @@ import (
 	"github.com/go-redis/redis"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
@@ -18,37 +21,39 @@ const (
 )
 
 var (
-	producer *kafka.Producer
+	producer     kafkaProducer
+	inquiryGroup singleflight.Group
+
+	errPublish        = errors.New("can't publish to kafka")
+	errProduceTimeout = errors.New("kafka didn't acknowledge the inquiry in time")
+	errNoResult       = errors.New("no response in redis")
+	// errBadResult is returned rather than panicking, a panic inside inquiryGroup.Do would never release the key
+	errBadResult = errors.New("undecodable response in redis")
 )
 
 func StartHttpServer() {
-	redisOpts := &redis.Options{
-		Addr:         redisAddress,
-		Password:     "", // no password set
-		DB:           0,  // use default DB
-		PoolSize:     10000,
-		MinIdleConns: 100,
-		PoolTimeout:  1 * time.Second,
-	}
-
 	initProducer()
-	initRedis(redisOpts)
+	initRedis(cfg.Redis.HTTP)
+	initBreakers()
 
-	r := mux.NewRouter()
-	r.HandleFunc("/inquiry/{id}", inquiry).Methods("GET")
-
-	log.Infof("HTTP server is listening...")
-	if err := http.ListenAndServe(":8080", r); err != nil {
+	if err := listenAndServe(newRouter()); err != nil {
 		panic(err)
 	}
 
 	log.Infof("Shutting down.")
 }
 
+func newRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/inquiry/{id}", inquiry).Methods("GET")
+	r.Use(correlate)
+	return r
+}
+
 func initProducer() {
-	log.WithField("topic", topic).Infof("Creating kafka producer")
+	log.WithField("topic", cfg.Kafka.Topic).Infof("Creating kafka producer")
 
-	p, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": broker})
+	p, err := kafka.NewProducer(kafkaConfig("producer", cfg.Kafka.Producer, kafka.ConfigMap{"bootstrap.servers": cfg.Kafka.Broker}))
 	if err != nil {
 		panic(err)
 	}
@@ -58,62 +63,177 @@ func initProducer() {
 
 func inquiry(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if !validID(id) {
+		writeProblem(w, r, "bad-input", http.StatusBadRequest, "id may only contain letters, digits, '.', '_', ':' and '-', up to 128 characters")
+		return
+	}
+
+	if cfg.Cache.Freshness > 0 {
+		if resBytes, age, ok := cachedResult(id); ok {
+			log.WithField("ID", id).WithField("Age", age).Infoln("Response served from cache")
+			w.Header().Set("X-Cache", "HIT")
+			w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(resBytes)
+			return
+		}
+	}
+
+	// Concurrent requests for the same ID share one kafka message and one poll
+	fetch := func() (interface{}, error) { return fetchResult(id) }
+	var (
+		v      interface{}
+		err    error
+		shared bool
+	)
+	if cfg.Cache.Coalesce {
+		v, err, shared = inquiryGroup.Do(id, fetch)
+	} else {
+		v, err = fetch()
+	}
+
+	if open, ok := err.(*errBreakerOpen); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(open.retryAfter.Seconds()))))
+		writeProblem(w, r, "unavailable", http.StatusServiceUnavailable, "Service temporarily unavailable, retry later")
+		return
+	}
+
+	switch err {
+	case nil:
+	case errPublish:
+		writeProblem(w, r, "unavailable", http.StatusServiceUnavailable, "Can't publish the inquiry to kafka")
+		return
+	case errNoResult:
+		writeProblem(w, r, "timeout", http.StatusGatewayTimeout, "No response from the backend in time")
+		return
+	case errBadResult:
+		writeProblem(w, r, "bad-gateway", http.StatusBadGateway, "The backend's response can't be decoded")
+		return
+	default:
+		writeProblem(w, r, "internal", http.StatusInternalServerError, "")
+		return
+	}
+
+	if shared {
+		w.Header().Set("X-Cache", "SHARED")
+	} else {
+		w.Header().Set("X-Cache", "MISS")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(v.([]byte))
+}
 
+// cachedResult reads the stored response of a previous inquiry, as long as it's younger than cache.freshness.
+// Its age is told by how much of ResultTTL is gone.
+func cachedResult(id string) ([]byte, time.Duration, bool) {
+	pipe := redisCli.Pipeline()
+	get := pipe.Get(resultKey(id))
+	ttl := pipe.PTTL(resultKey(id))
+	if _, err := pipe.Exec(); err != nil {
+		if err != redis.Nil {
+			log.Warnf("Redis Err: %v\n", err)
+		}
+		return nil, 0, false
+	}
+
+	age := ResultTTL - ttl.Val()
+	if ttl.Val() < 0 || age > cfg.Cache.Freshness {
+		return nil, 0, false
+	}
+	return []byte(get.Val()), age, true
+}
+
+// fetchResult publishes the inquiry to kafka and polls redis for its response. It fails fast with errBreakerOpen
+// while either circuit breaker is open.
+func fetchResult(id string) ([]byte, error) {
 	message := RequestMessage{}
 	err := faker.FakeData(&message)
 	if err != nil {
 		panic(err)
 	}
-	message.ID = vars["id"]
+	message.ID = id
+	message.Timestamp = time.Now()
+
+	responseDone, err := responseBreaker.allow()
+	if err != nil {
+		return nil, err
+	}
+	publishDone, err := publishBreaker.allow()
+	if err != nil {
+		// Nothing was learnt about redis, the unused probe counts as a success so a half-open breaker isn't left waiting
+		responseDone(true)
+		return nil, err
+	}
+
+	err = publish(message)
+	publishDone(err == nil)
+	if err != nil {
+		log.Errorf("Can't publish [%s]: %v", message.ID, err)
+		responseDone(true)
+		return nil, errPublish
+	}
+	log.Infof("Successfully delivered to kafka [%s]", message.ID)
+
+	resBytes, err := pollResult(message)
+	responseDone(err != errNoResult)
+	return resBytes, err
+}
+
+// publish produces the inquiry and waits up to kafka.produceTimeout for its delivery report.
+func publish(message RequestMessage) error {
 	mBytes, err := json.Marshal(message)
 	if err != nil {
 		panic(err)
 	}
 
-	delivery := make(chan kafka.Event)
+	// Buffered and never closed, a delivery report coming after the timeout mustn't block the producer or panic
+	delivery := make(chan kafka.Event, 1)
 
 	log.Infof("Publishing message [%s] to kafka", message.ID)
 	err = producer.Produce(&kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		TopicPartition: kafka.TopicPartition{Topic: &cfg.Kafka.Topic, Partition: kafka.PartitionAny},
 		Value:          mBytes,
 	}, delivery)
+	if err != nil {
+		return err
+	}
 
-	ev := <-delivery
-	km := ev.(*kafka.Message)
+	timer := time.NewTimer(cfg.Kafka.ProduceTimeout)
+	defer timer.Stop()
+	select {
+	case ev := <-delivery:
+		return ev.(*kafka.Message).TopicPartition.Error
+	case <-timer.C:
+		return errProduceTimeout
+	}
+}
 
-	if km.TopicPartition.Error != nil {
-		log.Errorf("Delivery failed of [%s]: %v", message.ID, km.TopicPartition.Error)
-		http.Error(w, "Can't publish to kafka !", 500)
-	} else {
-		log.Infof("Successfully delivered to kafka [%s]", message.ID)
-
-		var resBytes []byte
-		tryCount := 0
-
-		for tryCount < MaxTryCount {
-			tryCount++
-			time.Sleep(500 * time.Millisecond)
-
-			resBytes, err := redisCli.Get(fmt.Sprintf("id:%s", message.ID)).Bytes()
-			if err != nil {
-				log.Warnf("Redis Err: %v\n", err)
-				continue
-			}
-			res := ResponseMessage{}
-			err = json.Unmarshal(resBytes, &res)
-			if err != nil {
-				panic(err)
-			}
-			log.WithField("ID", res.ID).WithField("Amount", res.Amount).Infoln("Response received from redis")
+// pollResult polls redis for the response up to MaxTryCount times. Responses older than the inquiry were left
+// by a previous one and are skipped.
+func pollResult(message RequestMessage) ([]byte, error) {
+	for tryCount := 0; tryCount < MaxTryCount; tryCount++ {
+		time.Sleep(cfg.Inquiry.PollInterval)
 
-			w.Header().Set("Content-Type", "application/json")
-			w.Write(resBytes)
+		resBytes, err := redisCli.Get(resultKey(message.ID)).Bytes()
+		if err != nil {
+			log.Warnf("Redis Err: %v\n", err)
+			continue
 		}
-
-		if resBytes == nil {
-			http.Error(w, "Server Error", 500)
+		res := ResponseMessage{}
+		err = json.Unmarshal(resBytes, &res)
+		if err != nil {
+			log.Errorf("Can't decode the response of [%s]: %v", message.ID, err)
+			return nil, errBadResult
+		}
+		if res.Timestamp.Before(message.Timestamp) {
+			log.WithField("ID", res.ID).WithField("Timestamp", res.Timestamp).Debugln("SKIP response: older than the inquiry")
+			continue
 		}
+		log.WithField("ID", res.ID).WithField("Amount", res.Amount).Infoln("Response received from redis")
+		return resBytes, nil
 	}
 
-	close(delivery)
+	return nil, errNoResult
 }
@@ -6,34 +6,33 @@ import (
 	"os"
 	"time"
 
-	"github.com/go-redis/redis"
+	"github.com/amura2406/inquiry-kafka-redis-poc/internal/config"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
 	colorable "github.com/mattn/go-colorable"
 	log "github.com/sirupsen/logrus"
 )
 
 var (
-	broker        string
-	topic         string
-	consumerGroup string
-	redisAddress  string
-	asyncConsume  bool
-	delayMin      time.Duration
-	delayMax      time.Duration
-	redisCli      *redis.Client
+	cfg        = defaultConfig()
+	configFile string
+	dumpFormat string
+	redisCli   redisClient
 )
 
 type RequestMessage struct {
-	ID   string `faker:"username"`
-	Name string `faker:"name"`
-	Date string `faker:"date"`
+	ID        string `faker:"username"`
+	Name      string `faker:"name"`
+	Date      string `faker:"date"`
+	Timestamp time.Time
 }
 
 type ResponseMessage struct {
-	ID       string  `faker:"username"`
-	Name     string  `faker:"name"`
-	Date     string  `faker:"date"`
-	Currency string  `faker:"currency"`
-	Amount   float64 `faker:"amount"`
+	ID        string  `faker:"username"`
+	Name      string  `faker:"name"`
+	Date      string  `faker:"date"`
+	Currency  string  `faker:"currency"`
+	Amount    float64 `faker:"amount"`
+	Timestamp time.Time
 }
 
 func init() {
@@ -43,48 +42,108 @@ func init() {
 func main() {
 	consumerSubCmd := flag.NewFlagSet("consumer", flag.ExitOnError)
 	httpSubCmd := flag.NewFlagSet("http", flag.ExitOnError)
+	configSubCmd := flag.NewFlagSet("config", flag.ExitOnError)
 
-	consumerSubCmd.StringVar(&broker, "broker", "localhost", "Kafka broker address")
-	consumerSubCmd.StringVar(&topic, "topic", "poc-test", "Name of the topic")
-	consumerSubCmd.StringVar(&consumerGroup, "cg", "testCG", "Name of the Kafka consumer group")
-	consumerSubCmd.StringVar(&redisAddress, "redisAddr", "localhost:6379", "Redis address")
-	consumerSubCmd.DurationVar(&delayMin, "minD", 0*time.Second, "Minimum synthetic delay duration")
-	consumerSubCmd.DurationVar(&delayMax, "maxD", 0*time.Second, "Maximum synthetic delay duration")
-	consumerSubCmd.BoolVar(&asyncConsume, "async", true, "Whether to process each message from kafka asynchronously or not")
+	addCommonFlags(consumerSubCmd)
+	addConsumerFlags(consumerSubCmd)
+	consumerSubCmd.Var(config.Properties(cfg.Kafka.Consumer), "X", "librdkafka consumer property as key=value, can be repeated")
 
-	httpSubCmd.StringVar(&broker, "broker", "localhost", "Kafka broker address")
-	httpSubCmd.StringVar(&topic, "topic", "poc-test", "Name of the topic")
-	httpSubCmd.StringVar(&redisAddress, "redisAddr", "localhost:6379", "Redis address")
+	addCommonFlags(httpSubCmd)
+	addHttpFlags(httpSubCmd)
+	httpSubCmd.Var(config.Properties(cfg.Kafka.Producer), "X", "librdkafka producer property as key=value, can be repeated")
+
+	addCommonFlags(configSubCmd)
+	addConsumerFlags(configSubCmd)
+	addHttpFlags(configSubCmd)
+	configSubCmd.Var(config.Properties(cfg.Kafka.Properties), "X", "librdkafka property of both roles as key=value, can be repeated")
+	configSubCmd.StringVar(&dumpFormat, "format", "yaml", "Output format of config dump: yaml, toml or json")
 
 	if len(os.Args) < 2 {
-		fmt.Println("consumer or http sub command is required !")
+		fmt.Println("consumer, http or config sub command is required !")
 		os.Exit(1)
 	}
 
+	var err error
 	switch os.Args[1] {
 	case "consumer":
-		consumerSubCmd.Parse(os.Args[2:])
+		err = loadConfig(consumerSubCmd, os.Args[2:])
 	case "http":
-		httpSubCmd.Parse(os.Args[2:])
+		err = loadConfig(httpSubCmd, os.Args[2:])
+	case "config":
+		if len(os.Args) < 3 {
+			fmt.Println("validate or dump config action is required !")
+			os.Exit(1)
+		}
+		err = loadConfig(configSubCmd, os.Args[3:])
 	default:
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
+	if err != nil {
+		fmt.Printf("Invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+
 	if consumerSubCmd.Parsed() {
 		StartConsumer()
 	}
 	if httpSubCmd.Parsed() {
 		StartHttpServer()
 	}
+	if configSubCmd.Parsed() {
+		runConfigCommand(os.Args[2])
+	}
+}
+
+func addCommonFlags(fs *flag.FlagSet) {
+	fs.StringVar(&configFile, "config", "", "Config file (.yaml, .toml or .json), also read from INQUIRY_CONFIG")
+	fs.StringVar(&cfg.Kafka.Broker, "broker", cfg.Kafka.Broker, "Kafka broker address")
+	fs.StringVar(&cfg.Kafka.Topic, "topic", cfg.Kafka.Topic, "Name of the topic")
+	fs.StringVar(&cfg.Redis.Mode, "redisMode", cfg.Redis.Mode, "Redis deployment: single, cluster or sentinel")
+	fs.StringVar(&cfg.Redis.Address, "redisAddr", cfg.Redis.Address, "Redis address, comma separated seed nodes or sentinels in cluster and sentinel mode")
+	fs.StringVar(&cfg.Redis.MasterName, "redisMaster", cfg.Redis.MasterName, "Name of the primary monitored by the sentinels")
 }
 
-func initRedis(options *redis.Options) {
-	log.Infof("Initiating redis...")
-	redisCli = redis.NewClient(options)
+func addConsumerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.Kafka.ConsumerGroup, "cg", cfg.Kafka.ConsumerGroup, "Name of the Kafka consumer group")
+	fs.DurationVar(&cfg.Consumer.DelayMin, "minD", cfg.Consumer.DelayMin, "Minimum synthetic delay duration")
+	fs.DurationVar(&cfg.Consumer.DelayMax, "maxD", cfg.Consumer.DelayMax, "Maximum synthetic delay duration")
+	fs.BoolVar(&cfg.Consumer.Async, "async", cfg.Consumer.Async, "Whether to process each message from kafka asynchronously or not")
+}
 
-	err := redisCli.Ping().Err()
+func addHttpFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.HTTP.Address, "addr", cfg.HTTP.Address, "HTTP listen address")
+	fs.StringVar(&cfg.HTTP.TLSCert, "tlsCert", cfg.HTTP.TLSCert, "TLS certificate file, serves HTTPS when set")
+	fs.StringVar(&cfg.HTTP.TLSKey, "tlsKey", cfg.HTTP.TLSKey, "TLS private key file")
+	fs.StringVar(&cfg.HTTP.TLSClientCA, "tlsClientCA", cfg.HTTP.TLSClientCA, "CA bundle to verify client certificates against, enables mTLS when set")
+	fs.DurationVar(&cfg.HTTP.ReadHeaderTimeout, "readHeaderTimeout", cfg.HTTP.ReadHeaderTimeout, "Maximum duration for reading request headers")
+	fs.DurationVar(&cfg.HTTP.WriteTimeout, "writeTimeout", cfg.HTTP.WriteTimeout, "Maximum duration before timing out writes of the response")
+	fs.DurationVar(&cfg.HTTP.IdleTimeout, "idleTimeout", cfg.HTTP.IdleTimeout, "Maximum duration to wait for the next request on keep-alive connections")
+	fs.IntVar(&cfg.HTTP.MaxHeaderBytes, "maxHeaderBytes", cfg.HTTP.MaxHeaderBytes, "Maximum size of request headers in bytes")
+	fs.BoolVar(&cfg.HTTP.H2C, "h2c", cfg.HTTP.H2C, "Whether to serve HTTP/2 over cleartext connections")
+	fs.DurationVar(&cfg.Cache.Freshness, "cacheFresh", cfg.Cache.Freshness, "Serve the stored response of a previous inquiry for the same ID while younger than this, 0 to always ask the consumer")
+	fs.BoolVar(&cfg.Cache.Coalesce, "coalesce", cfg.Cache.Coalesce, "Whether concurrent requests for the same ID share one kafka message")
+	fs.DurationVar(&cfg.Kafka.ProduceTimeout, "produceTimeout", cfg.Kafka.ProduceTimeout, "How long to wait for kafka to acknowledge an inquiry")
+	fs.BoolVar(&cfg.Breaker.Enabled, "breaker", cfg.Breaker.Enabled, "Whether to fail fast with 503 while kafka or redis is failing")
+	fs.DurationVar(&cfg.Inquiry.PollInterval, "pollInterval", cfg.Inquiry.PollInterval, "How long to wait between polls of redis, the inquiry timing out after 20 of them")
+}
+
+func initRedis(pool RedisPoolConfig) {
+	log.WithField("mode", cfg.Redis.Mode).Infof("Initiating redis...")
+	cli, err := newRedisClient(pool)
+	if err != nil {
+		panic(err)
+	}
+	redisCli = cli
+
+	err = redisCli.Ping().Err()
 	if err != nil {
 		panic(err)
 	}
 }
+
+// kafkaConfig layers kafka.properties and the role's own properties on top of the given ones.
+func kafkaConfig(role string, roleProps map[string]string, base kafka.ConfigMap) *kafka.ConfigMap {
+	return config.KafkaConfigMap(role, cfg.Kafka.Properties, roleProps, base)
+}
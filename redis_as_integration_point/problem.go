@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"regexp"
+)
+
+const (
+	// ProblemContentType is the media type of error bodies, RFC 7807
+	ProblemContentType = "application/problem+json"
+	// ProblemTypePrefix makes the type URI of a problem, e.g. urn:inquiry:problem:timeout
+	ProblemTypePrefix = "urn:inquiry:problem:"
+
+	// CorrelationHeader carries the correlation id on requests and responses
+	CorrelationHeader = "X-Correlation-ID"
+	// RequestIDHeader is accepted as correlation id when CorrelationHeader is absent
+	RequestIDHeader = "X-Request-ID"
+
+	// InquiryIDMaxLength bounds inquiry IDs, they end up in kafka messages and redis keys
+	InquiryIDMaxLength = 128
+)
+
+// validIDChars keeps IDs to characters which are safe in URLs, log lines and redis hash tags
+var validIDChars = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+// problem is an RFC 7807 problem details object, extended with the correlation id of the request.
+type problem struct {
+	Type          string `json:"type"`
+	Title         string `json:"title"`
+	Status        int    `json:"status"`
+	Detail        string `json:"detail,omitempty"`
+	Instance      string `json:"instance,omitempty"`
+	CorrelationID string `json:"correlationId,omitempty"`
+}
+
+// correlate picks up the caller's correlation id, or makes one, and echoes it on the response.
+func correlate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(CorrelationHeader)
+		if id == "" {
+			id = r.Header.Get(RequestIDHeader)
+		}
+		if id == "" {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set(CorrelationHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validID(id string) bool {
+	return len(id) <= InquiryIDMaxLength && validIDChars.MatchString(id)
+}
+
+// writeProblem answers with a problem+json body carrying the correlation id set by correlate.
+func writeProblem(w http.ResponseWriter, r *http.Request, kind string, status int, detail string) {
+	b, err := json.Marshal(problem{
+		Type:          ProblemTypePrefix + kind,
+		Title:         http.StatusText(status),
+		Status:        status,
+		Detail:        detail,
+		Instance:      r.URL.Path,
+		CorrelationID: w.Header().Get(CorrelationHeader),
+	})
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(b)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	AdmissionOff      = "off"
+	AdmissionStatic   = "static"
+	AdmissionAIMD     = "aimd"
+	AdmissionGradient = "gradient"
+
+	// AdmissionRetryAfter is suggested to rejected callers, outstanding inquiries complete within seconds
+	AdmissionRetryAfter = time.Second
+	// Windows in samples of the short and long term latency averages of the gradient mode
+	GradientShortWindow = 10
+	GradientLongWindow  = 500
+	// GradientSmoothing is how much of the computed limit replaces the current one on every sample
+	GradientSmoothing = 0.2
+)
+
+var admissionLimiter *limiter
+
+// limiter bounds the inquiries outstanding at once, i.e. published but not answered yet. In the adaptive modes
+// the bound follows the latency observed: it grows while inquiries are answered quickly and shrinks once they
+// slow down or time out, so excess load is turned away instead of piling up in the wait map.
+type limiter struct {
+	mu       sync.Mutex
+	mode     string
+	limit    float64
+	inFlight int
+
+	// Latency averages of the gradient mode, in seconds
+	shortRtt float64
+	longRtt  float64
+}
+
+// admission is the slot held by one outstanding inquiry, its outcome adjusts the limit once released.
+type admission struct {
+	l       *limiter
+	start   time.Time
+	rtt     time.Duration
+	dropped bool
+	once    sync.Once
+}
+
+// errOverloaded is returned when the inquiry would exceed the concurrency limit.
+type errOverloaded struct {
+	limit int
+}
+
+func (e *errOverloaded) Error() string {
+	return fmt.Sprintf("too many outstanding inquiries, limit is %d", e.limit)
+}
+
+func initAdmission() {
+	admissionLimiter = &limiter{mode: cfg.Admission.Mode, limit: float64(cfg.Admission.Limit)}
+	admissionLimit.Set(admissionLimiter.limit)
+
+	addHealthInfo("admission", func() interface{} {
+		limit, inFlight := admissionLimiter.current()
+		return map[string]interface{}{
+			"mode":     cfg.Admission.Mode,
+			"limit":    limit,
+			"inFlight": inFlight,
+		}
+	})
+}
+
+// acquire takes a slot for an inquiry, failing with errOverloaded when none is left.
+func (l *limiter) acquire() (*admission, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.mode != AdmissionOff && l.inFlight >= int(l.limit) {
+		admissionRejectedTotal.Inc()
+		return nil, &errOverloaded{limit: int(l.limit)}
+	}
+	l.inFlight++
+	admissionInFlight.Set(float64(l.inFlight))
+	return &admission{l: l, start: time.Now()}, nil
+}
+
+func (l *limiter) current() (int, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit), l.inFlight
+}
+
+// answered records how long the inquiry took to be answered.
+func (a *admission) answered() {
+	a.rtt = time.Since(a.start)
+}
+
+// timedOut marks the inquiry as dropped, the strongest sign of overload.
+func (a *admission) timedOut() {
+	a.dropped = true
+}
+
+// release frees the slot. An inquiry neither answered nor timed out, e.g. the caller went away or the
+// publish failed, tells nothing about the load and leaves the limit alone.
+func (a *admission) release() {
+	a.once.Do(func() {
+		l := a.l
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		inFlight := l.inFlight
+		l.inFlight--
+		admissionInFlight.Set(float64(l.inFlight))
+
+		switch {
+		case a.dropped:
+			l.onDrop()
+		case a.rtt > 0:
+			l.onSample(a.rtt, inFlight)
+		}
+	})
+}
+
+func (l *limiter) onDrop() {
+	switch l.mode {
+	case AdmissionAIMD, AdmissionGradient:
+		l.setLimit(l.limit * cfg.Admission.BackoffRatio)
+	}
+}
+
+// onSample adjusts the limit to an answer taking rtt while inFlight inquiries were outstanding.
+func (l *limiter) onSample(rtt time.Duration, inFlight int) {
+	switch l.mode {
+	case AdmissionAIMD:
+		if rtt > cfg.Admission.LatencyThreshold {
+			l.onDrop()
+			return
+		}
+		// Only grow while the limit is actually used, an idle gateway proves nothing about higher load
+		if inFlight*2 >= int(l.limit) {
+			l.setLimit(l.limit + 1)
+		}
+	case AdmissionGradient:
+		l.onGradientSample(rtt.Seconds(), inFlight)
+	}
+}
+
+// onGradientSample compares recent latency to the long term one: while they match there's room for a queue of
+// sqrt(limit) more inquiries, once recent answers get slower than Tolerance allows the limit shrinks in proportion.
+func (l *limiter) onGradientSample(rtt float64, inFlight int) {
+	if l.longRtt == 0 {
+		l.shortRtt, l.longRtt = rtt, rtt
+		return
+	}
+	l.shortRtt = ewma(l.shortRtt, rtt, GradientShortWindow)
+	l.longRtt = ewma(l.longRtt, rtt, GradientLongWindow)
+
+	// Recover quickly once a latency spike is over instead of waiting for the long average to catch up
+	if l.longRtt > 2*l.shortRtt {
+		l.longRtt *= 0.95
+	}
+	if inFlight*2 < int(l.limit) {
+		return
+	}
+
+	gradient := math.Max(0.5, math.Min(1, cfg.Admission.Tolerance*l.longRtt/l.shortRtt))
+	newLimit := l.limit*gradient + math.Sqrt(l.limit)
+	l.setLimit(l.limit*(1-GradientSmoothing) + newLimit*GradientSmoothing)
+}
+
+func (l *limiter) setLimit(limit float64) {
+	limit = math.Max(float64(cfg.Admission.MinLimit), math.Min(float64(cfg.Admission.MaxLimit), limit))
+	if int(limit) != int(l.limit) {
+		log.WithField("from", int(l.limit)).WithField("to", int(limit)).Debug("Admission limit changed")
+	}
+	l.limit = limit
+	admissionLimit.Set(limit)
+}
+
+func ewma(avg, sample float64, window int) float64 {
+	alpha := 2 / float64(window+1)
+	return avg*(1-alpha) + sample*alpha
+}
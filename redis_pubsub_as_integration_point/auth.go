@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amura2406/inquiry-kafka-redis-poc/redis_pubsub_as_integration_point/inquirypb"
+	"github.com/amura2406/inquiry-kafka-redis-poc/redis_pubsub_as_integration_point/tracing"
+	"github.com/golang/protobuf/proto"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	AuthAPIKey = "apikey"
+	AuthHMAC   = "hmac"
+	AuthJWT    = "jwt"
+
+	// HMACScheme prefixes the Authorization header of signed requests: HMAC-SHA256 <principal>:<unix time>:<hex signature>
+	HMACScheme = "HMAC-SHA256"
+	// PrincipalKafkaHeader and AuthMethodKafkaHeader tell the consumer who made the inquiry
+	PrincipalKafkaHeader  = "principal"
+	AuthMethodKafkaHeader = "auth-method"
+	// PolicyAnyPrincipal is the policy entry of authenticated principals without one of their own
+	PolicyAnyPrincipal = "*"
+)
+
+// errNoCredentials is returned by an authenticator finding none of its credentials, the next one is tried.
+var errNoCredentials = errors.New("no credentials")
+
+var (
+	authenticators []authenticator
+	policy         map[string][]policyRule
+)
+
+// principal is the authenticated caller.
+type principal struct {
+	Name   string
+	Method string
+}
+
+// credentials is what a request presents, target being the request URI or the gRPC method and inquiry ID.
+type credentials struct {
+	get    func(key string) string
+	method string
+	target string
+}
+
+type authenticator interface {
+	authenticate(c credentials) (*principal, error)
+}
+
+// policyRule allows a route for the inquiry ids matching a glob pattern.
+type policyRule struct {
+	route string
+	id    string
+}
+
+type principalKey struct{}
+
+func initAuth() {
+	if !cfg.Auth.Enabled {
+		return
+	}
+
+	var methods []string
+	if len(cfg.Auth.APIKeys) > 0 {
+		authenticators = append(authenticators, apiKeyAuth{})
+		methods = append(methods, AuthAPIKey)
+	}
+	if len(cfg.Auth.HMACSecrets) > 0 {
+		authenticators = append(authenticators, hmacAuth{})
+		methods = append(methods, AuthHMAC)
+	}
+	if cfg.Auth.JWKSFile != "" {
+		keys, err := newJWKS(cfg.Auth.JWKSFile)
+		if err != nil {
+			panic(err)
+		}
+		authenticators = append(authenticators, &jwtAuth{keys: keys})
+		methods = append(methods, AuthJWT)
+	}
+
+	policy = map[string][]policyRule{}
+	for name, rules := range cfg.Auth.Policy {
+		policy[name] = parsePolicy(rules)
+	}
+	log.WithField("methods", strings.Join(methods, ",")).WithField("policies", len(policy)).Info("Authenticating inquiries")
+}
+
+// parsePolicy reads comma separated <route>:<id pattern> rules, e.g. inquiry:ACC-*,stream:*, route * matching any.
+// Validate made sure they're well formed.
+func parsePolicy(s string) []policyRule {
+	var rules []policyRule
+	for _, r := range strings.Split(s, ",") {
+		if r = strings.TrimSpace(r); r == "" {
+			continue
+		}
+		i := strings.Index(r, ":")
+		rules = append(rules, policyRule{route: r[:i], id: r[i+1:]})
+	}
+	return rules
+}
+
+func validatePolicy(s string) error {
+	for _, r := range strings.Split(s, ",") {
+		if r = strings.TrimSpace(r); r == "" {
+			continue
+		}
+		i := strings.Index(r, ":")
+		if i <= 0 {
+			return fmt.Errorf("expected <route>:<id pattern>, got %q", r)
+		}
+		if _, err := path.Match(r[i+1:], ""); err != nil {
+			return fmt.Errorf("%q: %v", r, err)
+		}
+	}
+	return nil
+}
+
+// authenticate tries every configured method in turn, the first one finding its credentials decides.
+func authenticate(c credentials) (*principal, error) {
+	for _, a := range authenticators {
+		p, err := a.authenticate(c)
+		if err == errNoCredentials {
+			continue
+		}
+		return p, err
+	}
+	return nil, errNoCredentials
+}
+
+// adminRoutes are only allowed to principals whose own policy entry names them, never by default.
+var adminRoutes = map[string]bool{"faults": true}
+
+// authorize checks the policy of the principal, falling back to the * entry. Without any policy every
+// authenticated principal may query everything but the admin routes.
+func authorize(p *principal, route, id string) error {
+	if adminRoutes[route] {
+		for _, r := range policy[p.Name] {
+			if r.route == route {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s may not use %s", p.Name, route)
+	}
+	if len(policy) == 0 {
+		return nil
+	}
+	rules, ok := policy[p.Name]
+	if !ok {
+		rules = policy[PolicyAnyPrincipal]
+	}
+	for _, r := range rules {
+		if r.route != route && r.route != "*" {
+			continue
+		}
+		if ok, _ := path.Match(r.id, id); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s may not query %s %s", p.Name, route, id)
+}
+
+func withPrincipal(ctx context.Context, p *principal) context.Context {
+	tracing.SpanFromContext(ctx).SetAttribute("enduser.id", p.Name)
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func principalFrom(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalKey{}).(*principal)
+	return p
+}
+
+// authenticateHTTP answers 401 to requests without valid credentials and 403 to those the policy doesn't allow,
+// handing the principal to the handlers through the context.
+func authenticateHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.Auth.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p, err := authenticate(credentials{get: r.Header.Get, method: r.Method, target: r.URL.RequestURI()})
+		if err != nil {
+			authFailuresTotal.WithLabelValues("unauthenticated").Inc()
+			logger(r.Context()).WithError(err).Info("Authentication failed")
+			w.Header().Set("WWW-Authenticate", authChallenge())
+			writeProblem(w, r, newProblem(ProblemUnauthenticated, http.StatusUnauthorized, "Valid credentials are required"))
+			return
+		}
+		ctx := withPrincipal(r.Context(), p)
+
+		route := ""
+		if cr := mux.CurrentRoute(r); cr != nil {
+			route = cr.GetName()
+		}
+		if err := authorize(p, route, mux.Vars(r)["id"]); err != nil {
+			authFailuresTotal.WithLabelValues("forbidden").Inc()
+			logger(ctx).WithError(err).Info("Authorization failed")
+			writeProblem(w, r.WithContext(ctx), newProblem(ProblemForbidden, http.StatusForbidden, "Not allowed to inquire this ID"))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authorizeRPC is authenticateHTTP for gRPC, credentials are read from the metadata.
+func authorizeRPC(ctx context.Context, route, id string) (context.Context, error) {
+	if !cfg.Auth.Enabled {
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	method, _ := grpc.Method(ctx)
+	get := func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	// The inquiry ID is part of the signed target, as it is of the HTTP request URI, so a signature can't be
+	// replayed for another ID
+	p, err := authenticate(credentials{get: get, method: http.MethodPost, target: method + "/" + id})
+	if err != nil {
+		authFailuresTotal.WithLabelValues("unauthenticated").Inc()
+		logger(ctx).WithError(err).Info("Authentication failed")
+		return ctx, status.Error(codes.Unauthenticated, "unauthenticated")
+	}
+	ctx = withPrincipal(ctx, p)
+
+	if err := authorize(p, route, id); err != nil {
+		authFailuresTotal.WithLabelValues("forbidden").Inc()
+		logger(ctx).WithError(err).Info("Authorization failed")
+		return ctx, status.Error(codes.PermissionDenied, "forbidden")
+	}
+	return ctx, nil
+}
+
+// authUnaryRPC authenticates and authorizes calls ahead of the rate limiter, so it keys buckets by principal as
+// rateLimit does for http.
+func authUnaryRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var id string
+	if r, ok := req.(interface{ GetId() string }); ok {
+		id = r.GetId()
+	}
+	ctx, err := authorizeRPC(ctx, rpcRoute(info.FullMethod), id)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authStreamRPC is authUnaryRPC for streams. The inquiry ID is signed, so the request is read here and handed
+// to the handler when it asks for it.
+func authStreamRPC(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !cfg.Auth.Enabled {
+		return handler(srv, ss)
+	}
+
+	req := new(inquirypb.InquiryRequest)
+	if err := ss.RecvMsg(req); err != nil {
+		return err
+	}
+	ctx, err := authorizeRPC(ss.Context(), rpcRoute(info.FullMethod), req.Id)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authorizedServerStream{ServerStream: ss, ctx: ctx, req: req})
+}
+
+// authorizedServerStream hands the principal carrying context and the request already read to stream handlers.
+type authorizedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	req proto.Message
+}
+
+func (s *authorizedServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *authorizedServerStream) RecvMsg(m interface{}) error {
+	dst, ok := m.(proto.Message)
+	if s.req == nil || !ok {
+		return s.ServerStream.RecvMsg(m)
+	}
+	proto.Merge(dst, s.req)
+	s.req = nil
+	return nil
+}
+
+// authChallenge lists the schemes accepted in the Authorization header.
+func authChallenge() string {
+	var schemes []string
+	if len(cfg.Auth.HMACSecrets) > 0 {
+		schemes = append(schemes, HMACScheme)
+	}
+	if cfg.Auth.JWKSFile != "" {
+		schemes = append(schemes, "Bearer")
+	}
+	if len(schemes) == 0 {
+		return "APIKey header=" + strconv.Quote(cfg.Auth.APIKeyHeader)
+	}
+	return strings.Join(schemes, ", ")
+}
+
+// apiKeyAuth accepts the static keys of auth.apiKeys in auth.apiKeyHeader.
+type apiKeyAuth struct{}
+
+func (apiKeyAuth) authenticate(c credentials) (*principal, error) {
+	key := c.get(cfg.Auth.APIKeyHeader)
+	if key == "" {
+		return nil, errNoCredentials
+	}
+	for name, k := range cfg.Auth.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return &principal{Name: name, Method: AuthAPIKey}, nil
+		}
+	}
+	return nil, errors.New("unknown API key")
+}
+
+// hmacAuth accepts requests signed with the secret shared with the principal. The signature is the hex HMAC-SHA256
+// of "<method>\n<target>\n<unix time>", only accepted within auth.hmacMaxSkew of that time.
+type hmacAuth struct{}
+
+func (hmacAuth) authenticate(c credentials) (*principal, error) {
+	authz := c.get("Authorization")
+	if !strings.HasPrefix(authz, HMACScheme+" ") {
+		return nil, errNoCredentials
+	}
+
+	parts := strings.Split(strings.TrimSpace(authz[len(HMACScheme)+1:]), ":")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed signature, expected <principal>:<unix time>:<signature>")
+	}
+	name, ts, sig := parts[0], parts[1], parts[2]
+
+	secret, ok := cfg.Auth.HMACSecrets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown principal %q", name)
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature time %q", ts)
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > cfg.Auth.HMACMaxSkew || skew < -cfg.Auth.HMACMaxSkew {
+		return nil, fmt.Errorf("signature time is %s off", skew)
+	}
+
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	if !hmac.Equal(got, hmacSignature(secret, c.method, c.target, ts)) {
+		return nil, errors.New("signature mismatch")
+	}
+	return &principal{Name: name, Method: AuthHMAC}, nil
+}
+
+func hmacSignature(secret, method, target, ts string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + target + "\n" + ts))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/go-redis/redis"
+)
+
+// kafkaProducer is what the http server needs of a *kafka.Producer, tests use an in-memory broker instead.
+type kafkaProducer interface {
+	Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error
+	GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error)
+	Close()
+}
+
+// kafkaConsumer is what the consumer needs of a *kafka.Consumer.
+type kafkaConsumer interface {
+	SubscribeTopics(topics []string, rebalanceCb kafka.RebalanceCb) error
+	ReadMessage(timeout time.Duration) (*kafka.Message, error)
+	Assignment() ([]kafka.TopicPartition, error)
+	QueryWatermarkOffsets(topic string, partition int32, timeoutMs int) (low, high int64, err error)
+	GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error)
+	Close() error
+}
+
+// redisClient is what both sub commands need of redis, scripts included. go-redis clients are adapted by
+// goRedis since their Subscribe hands out a concrete *redis.PubSub.
+type redisClient interface {
+	Ping() *redis.StatusCmd
+	Get(key string) *redis.StringCmd
+	Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd
+	Publish(channel string, message interface{}) *redis.IntCmd
+	Eval(script string, keys []string, args ...interface{}) *redis.Cmd
+	EvalSha(sha1 string, keys []string, args ...interface{}) *redis.Cmd
+	ScriptExists(hashes ...string) *redis.BoolSliceCmd
+	ScriptLoad(script string) *redis.StringCmd
+	SubscribeChannel(channel string) redisSubscription
+}
+
+// redisSubscription is a connection subscribed to a channel, a *redis.PubSub with go-redis.
+type redisSubscription interface {
+	ReceiveTimeout(timeout time.Duration) (interface{}, error)
+	Ping(payload ...string) error
+	Close() error
+}
+
+type goRedis struct {
+	redis.UniversalClient
+}
+
+func (c goRedis) SubscribeChannel(channel string) redisSubscription {
+	return c.Subscribe(channel)
+}
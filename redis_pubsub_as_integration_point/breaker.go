@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sony/gobreaker"
+)
+
+const (
+	BreakerPublish  = "kafka_publish"
+	BreakerResponse = "redis_response"
+)
+
+var (
+	publishBreaker  *breaker
+	responseBreaker *breaker
+)
+
+// breaker fails inquiries fast while a dependency is degraded, letting a few through once OpenTimeout
+// passed to find out whether it recovered.
+type breaker struct {
+	cb *gobreaker.TwoStepCircuitBreaker
+
+	mu       sync.Mutex
+	openedAt time.Time
+}
+
+// errBreakerOpen is returned instead of trying a dependency known to be failing.
+type errBreakerOpen struct {
+	name       string
+	retryAfter time.Duration
+}
+
+func (e *errBreakerOpen) Error() string {
+	return fmt.Sprintf("circuit breaker %s is open, retry after %s", e.name, e.retryAfter)
+}
+
+func initBreakers() {
+	publishBreaker = newBreaker(BreakerPublish)
+	responseBreaker = newBreaker(BreakerResponse)
+
+	addHealthInfo("breakers", func() interface{} {
+		return map[string]string{
+			BreakerPublish:  publishBreaker.state().String(),
+			BreakerResponse: responseBreaker.state().String(),
+		}
+	})
+}
+
+func newBreaker(name string) *breaker {
+	b := &breaker{}
+	circuitBreakerState.WithLabelValues(name).Set(float64(gobreaker.StateClosed))
+
+	b.cb = gobreaker.NewTwoStepCircuitBreaker(gobreaker.Settings{
+		Name:        name,
+		MaxRequests: uint32(cfg.Breaker.HalfOpenRequests),
+		Interval:    cfg.Breaker.Interval,
+		Timeout:     cfg.Breaker.OpenTimeout,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.Requests >= uint32(cfg.Breaker.MinRequests) &&
+				float64(counts.TotalFailures)/float64(counts.Requests) >= cfg.Breaker.FailureRatio
+		},
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			if to == gobreaker.StateOpen {
+				b.mu.Lock()
+				b.openedAt = time.Now()
+				b.mu.Unlock()
+			}
+			circuitBreakerState.WithLabelValues(name).Set(float64(to))
+			circuitBreakerTransitionsTotal.WithLabelValues(name, to.String()).Inc()
+			log.WithField("breaker", name).WithField("from", from.String()).WithField("to", to.String()).Warn("Circuit breaker changed state")
+		},
+	})
+	return b
+}
+
+// allow asks the breaker for permission, the returned func must be told whether the attempt succeeded.
+// It's a no-op when breakers are disabled.
+func (b *breaker) allow() (func(success bool), error) {
+	if !cfg.Breaker.Enabled {
+		return func(bool) {}, nil
+	}
+
+	done, err := b.cb.Allow()
+	if err != nil {
+		circuitBreakerRejectedTotal.WithLabelValues(b.cb.Name()).Inc()
+		return nil, &errBreakerOpen{name: b.cb.Name(), retryAfter: b.retryAfter()}
+	}
+	return done, nil
+}
+
+// retryAfter is the time left before the breaker lets probes through again, at least a second.
+func (b *breaker) retryAfter() time.Duration {
+	b.mu.Lock()
+	left := cfg.Breaker.OpenTimeout - time.Since(b.openedAt)
+	b.mu.Unlock()
+
+	if left < time.Second {
+		return time.Second
+	}
+	return left
+}
+
+func (b *breaker) state() gobreaker.State {
+	return b.cb.State()
+}
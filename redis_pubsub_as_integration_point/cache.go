@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// CacheKeyPrefix namespaces cached responses, the ID being the hash tag like the consumer keys
+	CacheKeyPrefix = "inquiry:cache:"
+
+	// Where an inquiry's response came from, as told by the X-Cache header
+	CacheHit    = "HIT"
+	CacheMiss   = "MISS"
+	CacheShared = "SHARED"
+	CacheStale  = "STALE"
+
+	// Why a stale response was served
+	StaleOnSlow  = "slow"
+	StaleOnError = "error"
+
+	// StaleWarning marks stale responses, as defined by RFC 7234
+	StaleWarning = `110 - "Response is Stale"`
+)
+
+var (
+	inquiryGroup singleflight.Group
+
+	errInquiryTimeout = errors.New("too long waiting")
+)
+
+// resolved is the final response of an inquiry and where it came from.
+type resolved struct {
+	Response *ResponseMessage
+	Source   string
+	// Age of a cached response
+	Age time.Duration
+}
+
+type cachedResponse struct {
+	Response *ResponseMessage `json:"response"`
+	CachedAt time.Time        `json:"cachedAt"`
+}
+
+// resolveInquiry answers from the cache while the response is fresh, otherwise it waits for the final response
+// through kafka. Concurrent inquiries for the same ID share one round trip when coalescing. With cache.staleFor
+// the last known response is served when the fresh one is slower than cache.staleTimeout or fails, while the
+// round trip goes on to refresh the cache.
+func resolveInquiry(ctx context.Context, id string) (*resolved, error) {
+	var cached *cachedResponse
+	if cachingEnabled() {
+		var ok bool
+		if cached, ok = lookupCache(ctx, id); ok && time.Since(cached.CachedAt) <= cfg.Cache.Freshness {
+			cacheLookupsTotal.WithLabelValues("hit").Inc()
+			return &resolved{Response: cached.Response, Source: CacheHit, Age: time.Since(cached.CachedAt)}, nil
+		}
+		cacheLookupsTotal.WithLabelValues("miss").Inc()
+	}
+
+	if !cfg.Cache.Coalesce && cached == nil {
+		resp, err := awaitInquiry(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return &resolved{Response: resp, Source: CacheMiss}, nil
+	}
+
+	// The round trip outlives the caller starting it since others, or the cache, may still be waiting for it
+	var ch <-chan singleflight.Result
+	round := func() (interface{}, error) { return awaitInquiry(detachedContext{ctx}, id) }
+	if cfg.Cache.Coalesce {
+		ch = inquiryGroup.DoChan(id, round)
+	} else {
+		results := make(chan singleflight.Result, 1)
+		go func() {
+			v, err := round()
+			results <- singleflight.Result{Val: v, Err: err}
+		}()
+		ch = results
+	}
+
+	var staleTimeout <-chan time.Time
+	if cached != nil {
+		timer := time.NewTimer(cfg.Cache.StaleTimeout)
+		defer timer.Stop()
+		staleTimeout = timer.C
+	}
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			if cached != nil && res.Err != context.Canceled {
+				return serveStale(ctx, id, cached, StaleOnError, res.Err), nil
+			}
+			return nil, res.Err
+		}
+		source := CacheMiss
+		if res.Shared {
+			source = CacheShared
+			coalescedTotal.Inc()
+		}
+		return &resolved{Response: res.Val.(*ResponseMessage), Source: source}, nil
+	case <-staleTimeout:
+		return serveStale(ctx, id, cached, StaleOnSlow, nil), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// serveStale answers with the last known response, the round trip refreshing it goes on regardless.
+func serveStale(ctx context.Context, id string, cached *cachedResponse, reason string, err error) *resolved {
+	staleServedTotal.WithLabelValues(reason).Inc()
+	l := logger(ctx).WithField("ID", id).WithField("reason", reason)
+	if err != nil {
+		l = l.WithError(err)
+	}
+	l.Info("Serving the last known response")
+	return &resolved{Response: cached.Response, Source: CacheStale, Age: time.Since(cached.CachedAt)}
+}
+
+// awaitInquiry publishes the inquiry and waits for its final response up to inquiry.timeout, caching it.
+func awaitInquiry(ctx context.Context, id string) (*ResponseMessage, error) {
+	p, err := publishInquiry(ctx, id, false, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer p.release()
+
+	timer := time.NewTimer(cfg.Inquiry.Timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case resp := <-p.Responses:
+			if resp.Partial {
+				continue
+			}
+			p.answered()
+			msgLogger(ctx).WithField("ID", resp.ID).WithField("Amount", resp.Amount).Info("Response received from redis")
+			storeCache(ctx, resp)
+			return resp, nil
+		case <-timer.C:
+			p.timedOut()
+			return nil, errInquiryTimeout
+		case <-ctx.Done():
+			// Only inquiry.timeout running out counts against the backend, not the caller giving up
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func cacheKey(id string) string {
+	return CacheKeyPrefix + "{" + id + "}"
+}
+
+func lookupCache(ctx context.Context, id string) (*cachedResponse, bool) {
+	b, err := redisCli.Get(cacheKey(id)).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			errorsTotal.WithLabelValues("cache").Inc()
+			logger(ctx).WithField("ID", id).WithError(err).Warn("Can't read the cache")
+		}
+		return nil, false
+	}
+
+	var cached cachedResponse
+	if err := json.Unmarshal(b, &cached); err != nil || cached.Response == nil {
+		logger(ctx).WithField("ID", id).WithError(err).Warn("Ignoring malformed cache entry")
+		return nil, false
+	}
+	return &cached, true
+}
+
+// cachingEnabled tells whether responses are cached, to be served fresh or as a fallback.
+func cachingEnabled() bool {
+	return cfg.Cache.Freshness > 0 || cfg.Cache.StaleFor > 0
+}
+
+// storeCache keeps the response for as long as it's fresh and then cache.staleFor longer to fall back on.
+func storeCache(ctx context.Context, resp *ResponseMessage) {
+	if !cachingEnabled() {
+		return
+	}
+
+	b, err := json.Marshal(cachedResponse{Response: resp, CachedAt: time.Now()})
+	if err != nil {
+		panic(err)
+	}
+	if err := redisCli.Set(cacheKey(resp.ID), b, cfg.Cache.Freshness+cfg.Cache.StaleFor).Err(); err != nil {
+		errorsTotal.WithLabelValues("cache").Inc()
+		logger(ctx).WithField("ID", resp.ID).WithError(err).Warn("Can't cache the response")
+	}
+}
+
+// detachedContext keeps the values of its parent, e.g. the trace and correlation id, but not its cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
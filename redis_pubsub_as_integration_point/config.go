@@ -0,0 +1,512 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/amura2406/inquiry-kafka-redis-poc/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// Config holds every setting of both sub commands. It's layered from lowest to highest precedence:
+// built-in defaults, the config file, INQUIRY_* environment variables and finally command line flags.
+type Config struct {
+	Kafka     KafkaConfig     `config:"kafka"`
+	Redis     RedisConfig     `config:"redis"`
+	Inquiry   InquiryConfig   `config:"inquiry"`
+	HTTP      HTTPConfig      `config:"http"`
+	GRPC      GRPCConfig      `config:"grpc"`
+	Consumer  ConsumerConfig  `config:"consumer"`
+	Tracing   TracingConfig   `config:"tracing"`
+	Log       LogConfig       `config:"log"`
+	Breaker   BreakerConfig   `config:"breaker"`
+	Admission AdmissionConfig `config:"admission"`
+	RateLimit RateLimitConfig `config:"rateLimit"`
+	Auth      AuthConfig      `config:"auth"`
+	Cache     CacheConfig     `config:"cache"`
+	Faults    FaultConfig     `config:"faults"`
+}
+
+type KafkaConfig struct {
+	Broker        string `config:"broker"`
+	Topic         string `config:"topic"`
+	ConsumerGroup string `config:"consumerGroup"`
+	// ProduceTimeout bounds the wait for the broker to acknowledge an inquiry
+	ProduceTimeout time.Duration `config:"produceTimeout"`
+	// Properties are handed to librdkafka as is, on both the producer and the consumer
+	Properties map[string]string `config:"properties"`
+	// Producer and Consumer properties only apply to their role, taking precedence over Properties
+	Producer map[string]string `config:"producer"`
+	Consumer map[string]string `config:"consumer"`
+}
+
+type RedisConfig struct {
+	// Mode is single, cluster or sentinel
+	Mode string `config:"mode"`
+	// Address is comma separated: the node, the cluster seed nodes or the sentinels depending on the mode
+	Address string `config:"address"`
+	// MasterName is the primary monitored by the sentinels
+	MasterName string          `config:"masterName"`
+	Password   string          `config:"password" secret:"true"`
+	DB         int             `config:"db"`
+	Channel    string          `config:"channel"`
+	HTTP       RedisPoolConfig `config:"http"`
+	Consumer   RedisPoolConfig `config:"consumer"`
+}
+
+type RedisPoolConfig struct {
+	PoolSize     int           `config:"poolSize"`
+	MinIdleConns int           `config:"minIdleConns"`
+	PoolTimeout  time.Duration `config:"poolTimeout"`
+}
+
+type InquiryConfig struct {
+	// Timeout bounds how long a caller waits for the final response
+	Timeout time.Duration `config:"timeout"`
+	// StreamIdleTimeout bounds how long a stream waits for the next response
+	StreamIdleTimeout time.Duration `config:"streamIdleTimeout"`
+	// StaleAfter is the age after which requests and responses are no longer worth processing
+	StaleAfter time.Duration `config:"staleAfter"`
+}
+
+type HTTPConfig struct {
+	Address           string        `config:"address"`
+	TLSCert           string        `config:"tlsCert"`
+	TLSKey            string        `config:"tlsKey"`
+	TLSClientCA       string        `config:"tlsClientCA"`
+	ReadHeaderTimeout time.Duration `config:"readHeaderTimeout"`
+	WriteTimeout      time.Duration `config:"writeTimeout"`
+	IdleTimeout       time.Duration `config:"idleTimeout"`
+	MaxHeaderBytes    int           `config:"maxHeaderBytes"`
+	H2C               bool          `config:"h2c"`
+}
+
+type GRPCConfig struct {
+	Address string `config:"address"`
+}
+
+type ConsumerConfig struct {
+	// AdminAddress serves /metrics, /healthz and /readyz, empty to disable
+	AdminAddress string `config:"adminAddress"`
+	Async        bool   `config:"async"`
+	// Delay is the distribution of the synthetic delay: fixed, uniform, normal, exponential, lognormal or empirical
+	Delay string `config:"delay"`
+	// DelayMin and DelayMax bound the uniform distribution and clamp the others, a DelayMax of 0 leaving them unbounded
+	DelayMin time.Duration `config:"delayMin"`
+	DelayMax time.Duration `config:"delayMax"`
+	// DelayMean and DelayStdDev shape the fixed, normal, exponential and lognormal distributions
+	DelayMean   time.Duration `config:"delayMean"`
+	DelayStdDev time.Duration `config:"delayStdDev"`
+	// DelayHistogram is the file of the empirical distribution, one "<upper bound> <weight>" bucket per line
+	DelayHistogram string `config:"delayHistogram"`
+	// Seed makes the synthetic delays reproducible, 0 seeds from the clock
+	Seed  int `config:"seed"`
+	Parts int `config:"parts"`
+}
+
+type TracingConfig struct {
+	// Exporter is none, stdout, file or otlp
+	Exporter string `config:"exporter"`
+	// File receives one JSON span per line with the file exporter
+	File string `config:"file"`
+	// Endpoint is the OTLP/HTTP traces URL of the collector
+	Endpoint string `config:"endpoint"`
+	// ServiceName defaults to inquiry-http or inquiry-consumer depending on the sub command
+	ServiceName string `config:"serviceName"`
+	// SampleRatio is the share of new traces recorded, traces started upstream follow the caller's decision
+	SampleRatio float64 `config:"sampleRatio"`
+}
+
+type LogConfig struct {
+	// Format is text or json
+	Format string `config:"format"`
+	// Level is debug, info, warn or error
+	Level string `config:"level"`
+	// SampleRate keeps the per-message info lines of one inquiry in SampleRate, 1 keeps them all
+	SampleRate int `config:"sampleRate"`
+}
+
+// BreakerConfig applies to both the kafka publish and the redis response circuit breakers.
+type BreakerConfig struct {
+	Enabled bool `config:"enabled"`
+	// FailureRatio of the requests counted within Interval trips the breaker, once there are MinRequests of them
+	FailureRatio float64       `config:"failureRatio"`
+	MinRequests  int           `config:"minRequests"`
+	Interval     time.Duration `config:"interval"`
+	// OpenTimeout is how long the breaker fails fast before letting HalfOpenRequests probes through
+	OpenTimeout      time.Duration `config:"openTimeout"`
+	HalfOpenRequests int           `config:"halfOpenRequests"`
+}
+
+// AdmissionConfig bounds the inquiries outstanding at the gateway, rejecting the excess with 429.
+type AdmissionConfig struct {
+	// Mode is off, static, aimd or gradient
+	Mode string `config:"mode"`
+	// Limit is the fixed limit in static mode and the initial one in the adaptive modes, bounded by MinLimit and MaxLimit
+	Limit    int `config:"limit"`
+	MinLimit int `config:"minLimit"`
+	MaxLimit int `config:"maxLimit"`
+	// LatencyThreshold is the answer latency above which the aimd mode backs off
+	LatencyThreshold time.Duration `config:"latencyThreshold"`
+	// BackoffRatio multiplies the limit when the adaptive modes back off
+	BackoffRatio float64 `config:"backoffRatio"`
+	// Tolerance is how much slower than the long term latency answers may get before the gradient mode shrinks the limit
+	Tolerance float64 `config:"tolerance"`
+}
+
+// RateLimitConfig gives every client a token bucket per route. Rates are written <tokens per second>/<burst>.
+type RateLimitConfig struct {
+	Enabled bool `config:"enabled"`
+	// Backend is local, limiting every instance on its own, or redis, sharing the buckets between instances
+	Backend string `config:"backend"`
+	// ClientHeader carries the API key identifying the client, else it's the client certificate subject or the address
+	ClientHeader string `config:"clientHeader"`
+	// Rate and Burst apply to routes without their own entry in Routes
+	Rate  float64 `config:"rate"`
+	Burst int     `config:"burst"`
+	// Routes maps inquiry, stream or ws to their own rate, e.g. stream: 1/5
+	Routes map[string]string `config:"routes"`
+}
+
+// AuthConfig authenticates inquiries with every method configured: static API keys, HMAC signed requests
+// and JWTs verified against a JWKS file. Maps are keyed by principal.
+type AuthConfig struct {
+	Enabled      bool              `config:"enabled"`
+	APIKeyHeader string            `config:"apiKeyHeader"`
+	APIKeys      map[string]string `config:"apiKeys" secret:"true"`
+	HMACSecrets  map[string]string `config:"hmacSecrets" secret:"true"`
+	// HMACMaxSkew is how far the time of a signed request may be from now
+	HMACMaxSkew time.Duration `config:"hmacMaxSkew"`
+	JWKSFile    string        `config:"jwksFile"`
+	// JWTIssuer and JWTAudience are checked against iss and aud when set
+	JWTIssuer   string `config:"jwtIssuer"`
+	JWTAudience string `config:"jwtAudience"`
+	// PrincipalClaim names the principal in the JWT
+	PrincipalClaim string `config:"principalClaim"`
+	// Policy lists the <route>:<id pattern> a principal may query, comma separated, * applies to the others
+	Policy map[string]string `config:"policy"`
+}
+
+type CacheConfig struct {
+	// Freshness is how long a final response is served from redis without asking the consumer again, 0 to disable
+	Freshness time.Duration `config:"freshness"`
+	// Coalesce makes concurrent inquiries for the same ID share one kafka round trip
+	Coalesce bool `config:"coalesce"`
+	// StaleFor keeps responses this long past their freshness to be served when the consumer is slow or
+	// failing, 0 to disable
+	StaleFor time.Duration `config:"staleFor"`
+	// StaleTimeout is how long to wait for the fresh response before serving the stale one
+	StaleTimeout time.Duration `config:"staleTimeout"`
+}
+
+type FaultConfig struct {
+	// Enabled wraps kafka and redis to inject the faults below, also changed at runtime through /faults
+	Enabled bool       `config:"enabled"`
+	Produce FaultRules `config:"produce"`
+	Consume FaultRules `config:"consume"`
+	Publish FaultRules `config:"publish"`
+}
+
+// FaultRules are the probabilities of each fault at one point, at most one fault applying to a message.
+type FaultRules struct {
+	Drop      float64 `config:"drop"`
+	Duplicate float64 `config:"duplicate"`
+	Reorder   float64 `config:"reorder"`
+	Corrupt   float64 `config:"corrupt"`
+	Delay     float64 `config:"delay"`
+	// DelayMin and DelayMax bound how long delayed messages are held
+	DelayMin time.Duration `config:"delayMin"`
+	DelayMax time.Duration `config:"delayMax"`
+	// ReorderWindow is how long a reordered message waits for the next one to overtake it
+	ReorderWindow time.Duration `config:"reorderWindow"`
+}
+
+func defaultFaultRules() FaultRules {
+	return FaultRules{DelayMin: 100 * time.Millisecond, DelayMax: time.Second, ReorderWindow: 100 * time.Millisecond}
+}
+
+func defaultConfig() Config {
+	return Config{
+		Kafka: KafkaConfig{
+			Broker:         "localhost",
+			Topic:          "poc-test",
+			ConsumerGroup:  "testCG",
+			ProduceTimeout: 5 * time.Second,
+			Properties:     map[string]string{},
+			Producer:       map[string]string{},
+			Consumer:       map[string]string{},
+		},
+		Redis: RedisConfig{
+			Mode:    RedisModeSingle,
+			Address: "localhost:6379",
+			Channel: "inquiry-response",
+			HTTP: RedisPoolConfig{
+				PoolSize:     1,
+				MinIdleConns: 1,
+				PoolTimeout:  1 * time.Second,
+			},
+			Consumer: RedisPoolConfig{
+				PoolSize:     10000,
+				MinIdleConns: 5,
+				PoolTimeout:  5 * time.Second,
+			},
+		},
+		Inquiry: InquiryConfig{
+			Timeout:           10 * time.Second,
+			StreamIdleTimeout: 10 * time.Second,
+			StaleAfter:        10 * time.Second,
+		},
+		HTTP: HTTPConfig{
+			Address:           ":8080",
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      15 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			MaxHeaderBytes:    1 << 20,
+		},
+		GRPC: GRPCConfig{
+			Address: ":9090",
+		},
+		Consumer: ConsumerConfig{
+			AdminAddress: ":8081",
+			Async:        true,
+			Delay:        DelayUniform,
+			Parts:        3,
+		},
+		Tracing: TracingConfig{
+			Exporter:    TraceExporterNone,
+			File:        "traces.jsonl",
+			Endpoint:    "http://localhost:4318/v1/traces",
+			SampleRatio: 1,
+		},
+		Log: LogConfig{
+			Format:     LogFormatText,
+			Level:      "info",
+			SampleRate: 100,
+		},
+		Breaker: BreakerConfig{
+			Enabled:          true,
+			FailureRatio:     0.5,
+			MinRequests:      20,
+			Interval:         10 * time.Second,
+			OpenTimeout:      5 * time.Second,
+			HalfOpenRequests: 5,
+		},
+		Admission: AdmissionConfig{
+			Mode:             AdmissionStatic,
+			Limit:            1000,
+			MinLimit:         10,
+			MaxLimit:         10000,
+			LatencyThreshold: 2 * time.Second,
+			BackoffRatio:     0.9,
+			Tolerance:        1.5,
+		},
+		RateLimit: RateLimitConfig{
+			Backend:      RateLimitLocal,
+			ClientHeader: "X-API-Key",
+			Rate:         10,
+			Burst:        20,
+			Routes:       map[string]string{},
+		},
+		Auth: AuthConfig{
+			APIKeyHeader:   "X-API-Key",
+			APIKeys:        map[string]string{},
+			HMACSecrets:    map[string]string{},
+			HMACMaxSkew:    5 * time.Minute,
+			PrincipalClaim: "sub",
+			Policy:         map[string]string{},
+		},
+		Cache: CacheConfig{
+			Coalesce:     true,
+			StaleTimeout: 2 * time.Second,
+		},
+		Faults: FaultConfig{
+			Produce: defaultFaultRules(),
+			Consume: defaultFaultRules(),
+			Publish: defaultFaultRules(),
+		},
+	}
+}
+
+// loadConfig parses the sub command flags and layers the config file and environment underneath them.
+func loadConfig(fs *flag.FlagSet, args []string) error {
+	if err := config.Load(&cfg, fs, args, &configFile); err != nil {
+		return err
+	}
+	return cfg.Validate()
+}
+
+// Validate reports the first setting that can't work.
+func (c *Config) Validate() error {
+	switch {
+	case c.Kafka.Broker == "":
+		return fmt.Errorf("kafka.broker is required")
+	case c.Kafka.Topic == "":
+		return fmt.Errorf("kafka.topic is required")
+	case c.Kafka.ConsumerGroup == "":
+		return fmt.Errorf("kafka.consumerGroup is required")
+	case c.Kafka.ProduceTimeout <= 0:
+		return fmt.Errorf("kafka.produceTimeout must be positive")
+	case c.Redis.Mode != RedisModeSingle && c.Redis.Mode != RedisModeCluster && c.Redis.Mode != RedisModeSentinel:
+		return fmt.Errorf("redis.mode must be single, cluster or sentinel")
+	case c.Redis.Address == "":
+		return fmt.Errorf("redis.address is required")
+	case c.Redis.Mode == RedisModeSentinel && c.Redis.MasterName == "":
+		return fmt.Errorf("redis.masterName is required in sentinel mode")
+	case c.Redis.Mode == RedisModeCluster && c.Redis.DB != 0:
+		return fmt.Errorf("redis.db must be 0 in cluster mode")
+	case c.Redis.Channel == "":
+		return fmt.Errorf("redis.channel is required")
+	case c.Redis.DB < 0:
+		return fmt.Errorf("redis.db can't be negative")
+	case c.Inquiry.Timeout <= 0:
+		return fmt.Errorf("inquiry.timeout must be positive")
+	case c.Inquiry.StreamIdleTimeout <= 0:
+		return fmt.Errorf("inquiry.streamIdleTimeout must be positive")
+	case c.Inquiry.StaleAfter <= 0:
+		return fmt.Errorf("inquiry.staleAfter must be positive")
+	case c.HTTP.Address == "":
+		return fmt.Errorf("http.address is required")
+	case (c.HTTP.TLSCert == "") != (c.HTTP.TLSKey == ""):
+		return fmt.Errorf("http.tlsCert and http.tlsKey must be set together")
+	case c.HTTP.TLSClientCA != "" && c.HTTP.TLSCert == "":
+		return fmt.Errorf("http.tlsClientCA requires http.tlsCert and http.tlsKey")
+	case c.HTTP.ReadHeaderTimeout < 0 || c.HTTP.WriteTimeout < 0 || c.HTTP.IdleTimeout < 0:
+		return fmt.Errorf("http timeouts can't be negative")
+	case c.HTTP.MaxHeaderBytes <= 0:
+		return fmt.Errorf("http.maxHeaderBytes must be positive")
+	case c.Consumer.DelayMin < 0 || c.Consumer.DelayMax < 0:
+		return fmt.Errorf("consumer delays can't be negative")
+	case c.Consumer.DelayMax < c.Consumer.DelayMin && (c.Consumer.Delay == DelayUniform || c.Consumer.DelayMax != 0):
+		return fmt.Errorf("consumer.delayMax can't be lower than consumer.delayMin")
+	case c.Consumer.Delay != DelayFixed && c.Consumer.Delay != DelayUniform && c.Consumer.Delay != DelayNormal &&
+		c.Consumer.Delay != DelayExponential && c.Consumer.Delay != DelayLogNormal && c.Consumer.Delay != DelayEmpirical:
+		return fmt.Errorf("consumer.delay must be fixed, uniform, normal, exponential, lognormal or empirical")
+	case c.Consumer.DelayMean < 0 || c.Consumer.DelayStdDev < 0:
+		return fmt.Errorf("consumer.delayMean and consumer.delayStdDev can't be negative")
+	case (c.Consumer.Delay == DelayExponential || c.Consumer.Delay == DelayLogNormal) && c.Consumer.DelayMean == 0:
+		return fmt.Errorf("consumer.delayMean must be positive with the %s distribution", c.Consumer.Delay)
+	case c.Consumer.Delay == DelayEmpirical && c.Consumer.DelayHistogram == "":
+		return fmt.Errorf("consumer.delayHistogram is required with the empirical distribution")
+	case c.Consumer.Parts < 0:
+		return fmt.Errorf("consumer.parts can't be negative")
+	case c.Tracing.Exporter != TraceExporterNone && c.Tracing.Exporter != TraceExporterStdout &&
+		c.Tracing.Exporter != TraceExporterFile && c.Tracing.Exporter != TraceExporterOTLP:
+		return fmt.Errorf("tracing.exporter must be none, stdout, file or otlp")
+	case c.Tracing.Exporter == TraceExporterFile && c.Tracing.File == "":
+		return fmt.Errorf("tracing.file is required with the file exporter")
+	case c.Tracing.Exporter == TraceExporterOTLP && c.Tracing.Endpoint == "":
+		return fmt.Errorf("tracing.endpoint is required with the otlp exporter")
+	case c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1:
+		return fmt.Errorf("tracing.sampleRatio must be between 0 and 1")
+	case c.Log.Format != LogFormatText && c.Log.Format != LogFormatJSON:
+		return fmt.Errorf("log.format must be text or json")
+	case c.Log.SampleRate <= 0:
+		return fmt.Errorf("log.sampleRate must be positive")
+	case c.Breaker.FailureRatio <= 0 || c.Breaker.FailureRatio > 1:
+		return fmt.Errorf("breaker.failureRatio must be above 0 and at most 1")
+	case c.Breaker.MinRequests <= 0:
+		return fmt.Errorf("breaker.minRequests must be positive")
+	case c.Breaker.Interval < 0:
+		return fmt.Errorf("breaker.interval can't be negative")
+	case c.Breaker.OpenTimeout <= 0:
+		return fmt.Errorf("breaker.openTimeout must be positive")
+	case c.Breaker.HalfOpenRequests <= 0:
+		return fmt.Errorf("breaker.halfOpenRequests must be positive")
+	case c.Admission.Mode != AdmissionOff && c.Admission.Mode != AdmissionStatic &&
+		c.Admission.Mode != AdmissionAIMD && c.Admission.Mode != AdmissionGradient:
+		return fmt.Errorf("admission.mode must be off, static, aimd or gradient")
+	case c.Admission.MinLimit <= 0 || c.Admission.MaxLimit < c.Admission.MinLimit:
+		return fmt.Errorf("admission.minLimit must be positive and at most admission.maxLimit")
+	case c.Admission.Limit < c.Admission.MinLimit || c.Admission.Limit > c.Admission.MaxLimit:
+		return fmt.Errorf("admission.limit must be between admission.minLimit and admission.maxLimit")
+	case c.Admission.LatencyThreshold <= 0:
+		return fmt.Errorf("admission.latencyThreshold must be positive")
+	case c.Admission.BackoffRatio <= 0 || c.Admission.BackoffRatio >= 1:
+		return fmt.Errorf("admission.backoffRatio must be between 0 and 1")
+	case c.Admission.Tolerance < 1:
+		return fmt.Errorf("admission.tolerance can't be lower than 1")
+	case c.RateLimit.Backend != RateLimitLocal && c.RateLimit.Backend != RateLimitRedis:
+		return fmt.Errorf("rateLimit.backend must be local or redis")
+	case c.RateLimit.ClientHeader == "":
+		return fmt.Errorf("rateLimit.clientHeader is required")
+	case c.RateLimit.Rate <= 0 || c.RateLimit.Burst <= 0:
+		return fmt.Errorf("rateLimit.rate and rateLimit.burst must be positive")
+	case c.Auth.Enabled && len(c.Auth.APIKeys) == 0 && len(c.Auth.HMACSecrets) == 0 && c.Auth.JWKSFile == "":
+		return fmt.Errorf("auth requires auth.apiKeys, auth.hmacSecrets or auth.jwksFile")
+	case c.Auth.APIKeyHeader == "":
+		return fmt.Errorf("auth.apiKeyHeader is required")
+	case c.Auth.HMACMaxSkew <= 0:
+		return fmt.Errorf("auth.hmacMaxSkew must be positive")
+	case c.Auth.PrincipalClaim == "":
+		return fmt.Errorf("auth.principalClaim is required")
+	case c.Cache.Freshness < 0 || c.Cache.StaleFor < 0:
+		return fmt.Errorf("cache.freshness and cache.staleFor can't be negative")
+	case c.Cache.StaleFor > 0 && (c.Cache.StaleTimeout <= 0 || c.Cache.StaleTimeout >= c.Inquiry.Timeout):
+		return fmt.Errorf("cache.staleTimeout must be positive and below inquiry.timeout")
+	}
+
+	for name, rules := range c.Auth.Policy {
+		if err := validatePolicy(rules); err != nil {
+			return fmt.Errorf("auth.policy.%s: %v", name, err)
+		}
+	}
+
+	if err := c.Faults.validate(); err != nil {
+		return err
+	}
+
+	for route, r := range c.RateLimit.Routes {
+		if _, err := parseRate(r); err != nil {
+			return fmt.Errorf("rateLimit.routes.%s: %v", route, err)
+		}
+	}
+
+	if _, err := log.ParseLevel(c.Log.Level); err != nil {
+		return fmt.Errorf("log.level: %v", err)
+	}
+
+	for name, pool := range map[string]RedisPoolConfig{"http": c.Redis.HTTP, "consumer": c.Redis.Consumer} {
+		switch {
+		case pool.PoolSize <= 0:
+			return fmt.Errorf("redis.%s.poolSize must be positive", name)
+		case pool.MinIdleConns < 0 || pool.MinIdleConns > pool.PoolSize:
+			return fmt.Errorf("redis.%s.minIdleConns must be between 0 and poolSize", name)
+		case pool.PoolTimeout <= 0:
+			return fmt.Errorf("redis.%s.poolTimeout must be positive", name)
+		}
+	}
+	return nil
+}
+
+func (c *FaultConfig) validate() error {
+	for point, r := range map[string]FaultRules{FaultProduce: c.Produce, FaultConsume: c.Consume, FaultPublish: c.Publish} {
+		switch {
+		case r.Drop < 0 || r.Duplicate < 0 || r.Reorder < 0 || r.Corrupt < 0 || r.Delay < 0:
+			return fmt.Errorf("faults.%s probabilities can't be negative", point)
+		case r.Drop+r.Duplicate+r.Reorder+r.Corrupt+r.Delay > 1:
+			return fmt.Errorf("faults.%s probabilities can't add up to more than 1", point)
+		case r.DelayMin < 0 || r.DelayMax < r.DelayMin:
+			return fmt.Errorf("faults.%s.delayMin can't be negative nor above delayMax", point)
+		case r.ReorderWindow <= 0:
+			return fmt.Errorf("faults.%s.reorderWindow must be positive", point)
+		}
+	}
+	return nil
+}
+
+func runConfigCommand(action string) {
+	switch action {
+	case "validate":
+		fmt.Println("Configuration is valid")
+	case "dump":
+		if err := config.Dump(&cfg, os.Stdout, dumpFormat); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Println("validate or dump config action is required !")
+		os.Exit(1)
+	}
+}
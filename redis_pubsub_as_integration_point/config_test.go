@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/amura2406/inquiry-kafka-redis-poc/internal/config"
+)
+
+func TestLoadEnv(t *testing.T) {
+	cfg := defaultConfig()
+	err := config.LoadEnv(&cfg, []string{
+		"INQUIRY_KAFKA_CONSUMER_GROUP=inquiries",
+		"INQUIRY_KAFKA_CONSUMER_FETCH_WAIT_MAX_MS=5",
+		"INQUIRY_KAFKA_PROPERTIES_LINGER_MS=10",
+		"INQUIRY_REDIS_HTTP_POOL_SIZE=7",
+		"OTHER_KAFKA_TOPIC=ignored",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Kafka.ConsumerGroup != "inquiries" {
+		t.Errorf("kafka.consumerGroup = %q, want inquiries", cfg.Kafka.ConsumerGroup)
+	}
+	if _, ok := cfg.Kafka.Consumer["group"]; ok {
+		t.Errorf("kafka.consumer = %v, INQUIRY_KAFKA_CONSUMER_GROUP set a group property", cfg.Kafka.Consumer)
+	}
+	if got := cfg.Kafka.Consumer["fetch.wait.max.ms"]; got != "5" {
+		t.Errorf("kafka.consumer[fetch.wait.max.ms] = %q, want 5", got)
+	}
+	if got := cfg.Kafka.Properties["linger.ms"]; got != "10" {
+		t.Errorf("kafka.properties[linger.ms] = %q, want 10", got)
+	}
+	if cfg.Redis.HTTP.PoolSize != 7 {
+		t.Errorf("redis.http.poolSize = %d, want 7", cfg.Redis.HTTP.PoolSize)
+	}
+	if cfg.Kafka.Topic == "ignored" {
+		t.Errorf("kafka.topic taken from a variable without the %s prefix", config.EnvPrefix)
+	}
+}
+
+// TestConfigDumpRoundTrip loads what config dump prints, in every format, back into the same configuration.
+func TestConfigDumpRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	want := defaultConfig()
+	want.HTTP.MaxHeaderBytes = 4 << 20
+	want.HTTP.IdleTimeout = 1500 * time.Millisecond
+	want.Tracing.SampleRatio = 0.25
+	want.Kafka.Producer["linger.ms"] = "5"
+
+	for _, format := range []string{"yaml", "toml", "json"} {
+		t.Run(format, func(t *testing.T) {
+			f, err := os.Create(filepath.Join(dir, "config."+format))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = config.Dump(&want, f, format)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := defaultConfig()
+			if err := config.LoadFile(&got, f.Name()); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("loaded %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -1,91 +1,133 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"math/rand"
+	"net/http"
+	"sync"
 	"time"
 
+	"github.com/amura2406/inquiry-kafka-redis-poc/redis_pubsub_as_integration_point/tracing"
 	"github.com/bxcodec/faker"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
-	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 )
 
 var (
-	consumer *kafka.Consumer
-	random   *rand.Rand
+	consumer kafkaConsumer
 )
 
 func StartConsumer() {
-	redisOpts := &redis.Options{
-		Addr:         redisAddress,
-		Password:     "", // no password set
-		DB:           0,  // use default DB
-		PoolSize:     10000,
-		MinIdleConns: 5,
-		PoolTimeout:  5 * time.Second,
-	}
-
-	initRandom()
+	initDelays()
+	initFaults()
+	initTracing("inquiry-consumer")
 	initConsumer()
-	initRedis(redisOpts)
+	initRedis(cfg.Redis.Consumer)
+
+	addHealthCheck("redis", checkRedis)
+	addHealthCheck("kafka_consumer", checkConsumer)
+	addHealthCheck("kafka_assignment", checkAssignment)
+
+	go serveAdmin()
+
+	log.Info("Listening now...")
+	consume(consumer, nil)
+}
+
+// consume processes the messages read by c until done is closed, closing c and waiting for the messages
+// still being processed.
+func consume(c kafkaConsumer, done <-chan struct{}) {
+	positions := newPartitionPositions()
+	go trackConsumerLag(c, positions, done)
+
+	go func() {
+		<-done
+		c.Close()
+	}()
+
+	var inFlight sync.WaitGroup
+	defer inFlight.Wait()
 
-	log.Infoln("Listening now...")
 	for {
-		msg, err := consumer.ReadMessage(-1)
+		msg, err := c.ReadMessage(-1)
 		if err == nil {
+			positions.set(msg.TopicPartition)
 			var metas map[string]interface{}
 			metaBytes, err := json.Marshal(msg.TopicPartition)
 			if err != nil {
 				panic(err)
 			}
 			json.Unmarshal(metaBytes, &metas)
-			if asyncConsume {
-				go processMessage(msg)
+			if cfg.Consumer.Async {
+				inFlight.Add(1)
+				go func() {
+					defer inFlight.Done()
+					processMessage(msg)
+				}()
 			} else {
 				processMessage(msg)
 			}
+		} else if isDone(done) {
+			return
 		} else {
 			// The client will automatically try to recover from all errors.
-			log.Errorf("Consumer error: %v (%v)\n", err, msg)
+			log.WithError(err).Error("Consumer error")
 		}
 	}
-
-	consumer.Close()
-}
-
-func initRandom() {
-	random = rand.New(rand.NewSource(time.Now().UnixNano()))
 }
 
 func initConsumer() {
-	log.Infoln("Consumer starting...")
-	c, err := kafka.NewConsumer(&kafka.ConfigMap{
-		"bootstrap.servers": broker,
-		"group.id":          consumerGroup,
+	log.Info("Consumer starting...")
+	c, err := kafka.NewConsumer(kafkaConfig("consumer", cfg.Kafka.Consumer, kafka.ConfigMap{
+		"bootstrap.servers": cfg.Kafka.Broker,
+		"group.id":          cfg.Kafka.ConsumerGroup,
 		"auto.offset.reset": "latest",
-	})
+	}))
 
 	if err != nil {
 		panic(err)
 	}
 
-	c.SubscribeTopics([]string{topic}, nil)
+	c.SubscribeTopics([]string{cfg.Kafka.Topic}, nil)
 
-	consumer = c
+	consumer = withConsumeFaults(c)
 }
 
 func processMessage(msg *kafka.Message) {
+	consumerInFlight.Inc()
+	defer consumerInFlight.Dec()
+
+	carrier := kafkaHeaderCarrier{msg: msg}
+	ctx := tracing.Extract(withCorrelationID(context.Background(), carrier.Get(CorrelationKafkaHeader)), carrier)
+	ctx, span := tracing.Start(ctx, cfg.Kafka.Topic+" process", tracing.KindConsumer)
+	defer span.End()
+	span.SetAttribute("messaging.system", "kafka")
+	span.SetAttribute("messaging.destination", cfg.Kafka.Topic)
+	span.SetAttribute("messaging.kafka.partition", msg.TopicPartition.Partition)
+	span.SetAttribute("messaging.kafka.offset", int64(msg.TopicPartition.Offset))
+	if name := carrier.Get(PrincipalKafkaHeader); name != "" {
+		ctx = withPrincipal(ctx, &principal{Name: name, Method: carrier.Get(AuthMethodKafkaHeader)})
+	}
+
 	reqMsg := RequestMessage{}
 	err := json.Unmarshal(msg.Value, &reqMsg)
 	if err != nil {
-		panic(err)
+		span.SetError(err)
+		errorsTotal.WithLabelValues("kafka_decode").Inc()
+		consumerProcessedTotal.WithLabelValues(OutcomeError).Inc()
+		logger(ctx).WithError(err).Error("Can't decode inquiry from kafka")
+		return
 	}
+	span.SetAttribute("inquiry.id", reqMsg.ID)
 
 	// Check whether it's still relevant
-	tenSecsAgo := time.Now().Add(-10 * time.Second)
-	if reqMsg.Timestamp.Before(tenSecsAgo) {
-		log.WithField("ID", reqMsg.ID).WithField("Timestamp", reqMsg.Timestamp).Debugf("SKIP message: too long ago")
+	staleBefore := time.Now().Add(-cfg.Inquiry.StaleAfter)
+	if reqMsg.Timestamp.Before(staleBefore) {
+		span.SetAttribute("inquiry.stale", true)
+		staleSkippedTotal.WithLabelValues("consumer").Inc()
+		consumerProcessedTotal.WithLabelValues("stale").Inc()
+		logger(ctx).WithField("ID", reqMsg.ID).WithField("Timestamp", reqMsg.Timestamp).Debug("SKIP message: too long ago")
 		return
 	}
 
@@ -98,24 +140,85 @@ func processMessage(msg *kafka.Message) {
 	resMsg.Name = reqMsg.Name
 	resMsg.Date = reqMsg.Date
 	resMsg.Timestamp = time.Now()
+	resMsg.CorrelationID = correlationID(ctx)
+	resMsg.ReplyTo = reqMsg.ReplyTo
+
+	delta := delays.sample()
+	msgLogger(ctx).WithField("ID", reqMsg.ID).WithField("Δ", delta).Info("Delay...")
+	if reqMsg.Stream && cfg.Consumer.Parts > 0 {
+		// Spread the synthetic delay evenly between partial responses and the final one
+		step := delta / time.Duration(cfg.Consumer.Parts+1)
+		for i := 0; i < cfg.Consumer.Parts; i++ {
+			time.Sleep(step)
+
+			partMsg := resMsg
+			partMsg.Seq = i
+			partMsg.Partial = true
+			partMsg.Amount = resMsg.Amount * float64(i+1) / float64(cfg.Consumer.Parts+1)
+			partMsg.Timestamp = time.Now()
+			if err := publishResponse(ctx, &partMsg); err != nil {
+				span.SetError(err)
+				errorsTotal.WithLabelValues("redis_publish").Inc()
+				consumerProcessedTotal.WithLabelValues(OutcomeError).Inc()
+				logger(ctx).WithField("ID", reqMsg.ID).WithField("Seq", i).WithError(err).Warn("Redis maybe too busy")
+				return
+			}
+		}
+		resMsg.Seq = cfg.Consumer.Parts
+		delta = step
+	}
+	time.Sleep(delta)
 
-	resBytes, err := json.Marshal(resMsg)
+	err = publishResponse(ctx, &resMsg)
 	if err != nil {
-		panic(err)
+		span.SetError(err)
+		errorsTotal.WithLabelValues("redis_publish").Inc()
+		consumerProcessedTotal.WithLabelValues(OutcomeError).Inc()
+		logger(ctx).WithField("ID", reqMsg.ID).WithError(err).Warn("Redis maybe too busy")
+		return
 	}
+	consumerProcessedTotal.WithLabelValues(OutcomeOK).Inc()
+	msgLogger(ctx).WithField("ID", reqMsg.ID).Info("Successfully publish to redis")
+}
 
-	Δ := int64(delayMax) - int64(delayMin)
-	if Δ > 0 {
-		randΔ := rand.Int63n(Δ)
-		delta := time.Duration(int64(delayMin) + randΔ)
-		log.WithField("Δ", delta).Infof("Delay...")
-		time.Sleep(delta)
-	}
+// publishResponse publishes within a producer span whose context travels inside the payload.
+func publishResponse(ctx context.Context, resMsg *ResponseMessage) error {
+	ctx, span := tracing.Start(ctx, cfg.Redis.Channel+" publish", tracing.KindProducer)
+	defer span.End()
+	span.SetAttribute("messaging.system", "redis")
+	span.SetAttribute("messaging.destination", cfg.Redis.Channel)
+	span.SetAttribute("inquiry.seq", resMsg.Seq)
+	span.SetAttribute("inquiry.partial", resMsg.Partial)
 
-	err = redisCli.Publish(redisChannel, string(resBytes)).Err()
+	resMsg.TraceParent = tracing.TraceParent(ctx)
+	resBytes, err := json.Marshal(resMsg)
 	if err != nil {
-		log.WithField("ID", reqMsg.ID).Warnf("Redis maybe too busy: %v\n", err)
+		span.SetError(err)
+		return err
+	}
+
+	err = redisCli.Publish(cfg.Redis.Channel, string(resBytes)).Err()
+	span.SetError(err)
+	return err
+}
+
+// serveAdmin exposes the consumer's metrics and health probes, it has no inquiry API of its own.
+func serveAdmin() {
+	if cfg.Consumer.AdminAddress == "" {
 		return
 	}
-	log.WithField("ID", reqMsg.ID).Infof("Successfully publish to redis")
+
+	// Credentials are only checked on /faults, the consumer has no inquiry API
+	initAuth()
+
+	r := mux.NewRouter()
+	r.Handle("/metrics", metricsHandler()).Methods("GET")
+	r.HandleFunc("/healthz", healthzHandler).Methods("GET")
+	r.HandleFunc("/readyz", readyzHandler).Methods("GET")
+	addFaultRoutes(r)
+
+	log.WithField("addr", cfg.Consumer.AdminAddress).Info("Admin server is listening...")
+	if err := http.ListenAndServe(cfg.Consumer.AdminAddress, r); err != nil {
+		panic(err)
+	}
 }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Distributions of the consumer's synthetic delay
+	DelayFixed       = "fixed"
+	DelayUniform     = "uniform"
+	DelayNormal      = "normal"
+	DelayExponential = "exponential"
+	DelayLogNormal   = "lognormal"
+	DelayEmpirical   = "empirical"
+)
+
+var delays *delayDistribution
+
+// delayDistribution draws the consumer's synthetic delays from its own source, seeded for reproducible runs.
+// Draws are clamped between min and max, a max of 0 leaving them unbounded.
+type delayDistribution struct {
+	mu   sync.Mutex
+	rng  *rand.Rand
+	draw func(rng *rand.Rand) time.Duration
+
+	min time.Duration
+	max time.Duration
+}
+
+func initDelays() {
+	d, err := newDelayDistribution(cfg.Consumer)
+	if err != nil {
+		panic(err)
+	}
+	delays = d
+}
+
+func newDelayDistribution(c ConsumerConfig) (*delayDistribution, error) {
+	seed := int64(c.Seed)
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	d := &delayDistribution{rng: rand.New(rand.NewSource(seed)), min: c.DelayMin, max: c.DelayMax}
+
+	mean, stdDev := float64(c.DelayMean), float64(c.DelayStdDev)
+	switch c.Delay {
+	case DelayFixed:
+		d.draw = func(*rand.Rand) time.Duration { return c.DelayMean }
+	case DelayUniform:
+		d.draw = func(rng *rand.Rand) time.Duration {
+			if Δ := int64(c.DelayMax - c.DelayMin); Δ > 0 {
+				return c.DelayMin + time.Duration(rng.Int63n(Δ))
+			}
+			return c.DelayMin
+		}
+	case DelayNormal:
+		d.draw = func(rng *rand.Rand) time.Duration { return time.Duration(mean + rng.NormFloat64()*stdDev) }
+	case DelayExponential:
+		d.draw = func(rng *rand.Rand) time.Duration { return time.Duration(rng.ExpFloat64() * mean) }
+	case DelayLogNormal:
+		// μ and σ of the underlying normal distribution giving the delays the configured mean and deviation
+		σ := math.Sqrt(math.Log(1 + stdDev*stdDev/(mean*mean)))
+		μ := math.Log(mean) - σ*σ/2
+		d.draw = func(rng *rand.Rand) time.Duration { return time.Duration(math.Exp(μ + σ*rng.NormFloat64())) }
+	case DelayEmpirical:
+		h, err := loadDelayHistogram(c.DelayHistogram)
+		if err != nil {
+			return nil, err
+		}
+		d.draw = h.draw
+	default:
+		return nil, fmt.Errorf("unknown delay distribution %q", c.Delay)
+	}
+
+	log.WithField("distribution", c.Delay).WithField("seed", seed).Info("Synthetic delays")
+	return d, nil
+}
+
+func (d *delayDistribution) sample() time.Duration {
+	d.mu.Lock()
+	delay := d.draw(d.rng)
+	d.mu.Unlock()
+
+	if delay < d.min {
+		delay = d.min
+	}
+	if d.max > 0 && delay > d.max {
+		delay = d.max
+	}
+	return delay
+}
+
+// delayHistogram is an empirical distribution: buckets of delays up to their bound, drawn by weight.
+type delayHistogram struct {
+	bounds []time.Duration
+	// cumulative weights of the buckets
+	weights []float64
+}
+
+// loadDelayHistogram reads one "<upper bound> <weight>" bucket per line, in increasing bounds, e.g. "250ms 12",
+// the first bucket starting at 0. Blank lines and lines starting with # are skipped.
+func loadDelayHistogram(path string) (*delayHistogram, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := &delayHistogram{}
+	var total float64
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(strings.Replace(line, ",", " ", -1))
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<upper bound> <weight>\"", path, n)
+		}
+		bound, err := time.ParseDuration(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		weight, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("%s:%d: weight must be a non-negative number", path, n)
+		}
+		if k := len(h.bounds); bound <= 0 || (k > 0 && bound <= h.bounds[k-1]) {
+			return nil, fmt.Errorf("%s:%d: bounds must be positive and increasing", path, n)
+		}
+
+		total += weight
+		h.bounds = append(h.bounds, bound)
+		h.weights = append(h.weights, total)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("%s: no bucket with a weight", path)
+	}
+	return h, nil
+}
+
+// draw picks a bucket by weight, then a delay uniformly within it.
+func (h *delayHistogram) draw(rng *rand.Rand) time.Duration {
+	target := rng.Float64() * h.weights[len(h.weights)-1]
+	i := 0
+	for i < len(h.weights)-1 && h.weights[i] <= target {
+		i++
+	}
+
+	var lower time.Duration
+	if i > 0 {
+		lower = h.bounds[i-1]
+	}
+	return lower + time.Duration(rng.Int63n(int64(h.bounds[i]-lower)))
+}
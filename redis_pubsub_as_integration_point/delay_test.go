@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "delays")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func writeHistogram(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDelayDistributions(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	histogram := writeHistogram(t, dir, "histogram.txt", "# bound weight\n10ms 3\n\n20ms 0\n100ms, 1\n")
+
+	cases := []struct {
+		name   string
+		config ConsumerConfig
+		// wantMean is the expected mean, matched within 5%
+		wantMean time.Duration
+		min, max time.Duration
+	}{
+		{"fixed", ConsumerConfig{Delay: DelayFixed, DelayMean: 30 * time.Millisecond}, 30 * time.Millisecond, 30 * time.Millisecond, 30 * time.Millisecond},
+		{"uniform", ConsumerConfig{Delay: DelayUniform, DelayMin: 10 * time.Millisecond, DelayMax: 30 * time.Millisecond}, 20 * time.Millisecond, 10 * time.Millisecond, 30 * time.Millisecond},
+		{"uniform without a range", ConsumerConfig{Delay: DelayUniform, DelayMin: 10 * time.Millisecond, DelayMax: 10 * time.Millisecond}, 10 * time.Millisecond, 10 * time.Millisecond, 10 * time.Millisecond},
+		{"normal", ConsumerConfig{Delay: DelayNormal, DelayMean: 100 * time.Millisecond, DelayStdDev: 10 * time.Millisecond}, 100 * time.Millisecond, 0, 0},
+		{"normal clamped", ConsumerConfig{Delay: DelayNormal, DelayMean: 100 * time.Millisecond, DelayStdDev: 50 * time.Millisecond, DelayMin: 90 * time.Millisecond, DelayMax: 110 * time.Millisecond}, 100 * time.Millisecond, 90 * time.Millisecond, 110 * time.Millisecond},
+		{"exponential", ConsumerConfig{Delay: DelayExponential, DelayMean: 50 * time.Millisecond}, 50 * time.Millisecond, 0, 0},
+		{"lognormal", ConsumerConfig{Delay: DelayLogNormal, DelayMean: 50 * time.Millisecond, DelayStdDev: 20 * time.Millisecond}, 50 * time.Millisecond, 0, 0},
+		// 3/4 of the draws average 5ms and 1/4 average 60ms, the empty bucket never being drawn
+		{"empirical", ConsumerConfig{Delay: DelayEmpirical, DelayHistogram: histogram}, 18750 * time.Microsecond, 0, 100 * time.Millisecond},
+	}
+
+	const draws = 100000
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.config.Seed = 1
+			d, err := newDelayDistribution(tc.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var sum float64
+			for i := 0; i < draws; i++ {
+				delay := d.sample()
+				if delay < tc.min || (tc.max > 0 && delay > tc.max) {
+					t.Fatalf("drew %s, want between %s and %s", delay, tc.min, tc.max)
+				}
+				if tc.config.Delay == DelayEmpirical && delay > 10*time.Millisecond && delay <= 20*time.Millisecond {
+					t.Fatalf("drew %s from an empty bucket", delay)
+				}
+				sum += float64(delay)
+			}
+			if mean := sum / draws; math.Abs(mean-float64(tc.wantMean)) > 0.05*float64(tc.wantMean) {
+				t.Errorf("mean %s, want %s", time.Duration(mean), tc.wantMean)
+			}
+		})
+	}
+}
+
+func TestDelaySeedIsReproducible(t *testing.T) {
+	c := ConsumerConfig{Delay: DelayExponential, DelayMean: 50 * time.Millisecond, Seed: 42}
+	a, _ := newDelayDistribution(c)
+	b, _ := newDelayDistribution(c)
+	for i := 0; i < 100; i++ {
+		if x, y := a.sample(), b.sample(); x != y {
+			t.Fatalf("draw %d: %s and %s with the same seed", i, x, y)
+		}
+	}
+}
+
+func TestDelayHistogramErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	for name, content := range map[string]string{
+		"missing weight":     "10ms\n",
+		"bad bound":          "10 3\n",
+		"negative weight":    "10ms -1\n",
+		"decreasing bounds":  "20ms 1\n10ms 1\n",
+		"no weight at all":   "10ms 0\n",
+		"only comments":      "# nothing\n",
+		"too many fields":    "10ms 1 2\n",
+		"non-numeric weight": "10ms many\n",
+	} {
+		if _, err := loadDelayHistogram(writeHistogram(t, dir, name, content)); err == nil {
+			t.Errorf("%s: loaded", name)
+		}
+	}
+}
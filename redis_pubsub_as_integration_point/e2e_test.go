@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// e2eCase is one scenario of the /inquiry/{id} contract, run against a fresh flow.
+type e2eCase struct {
+	name      string
+	configure func(c *Config)
+	// before runs once the flow is up, during once the first inquiry reached kafka
+	before func(t *testing.T, f *testFlow)
+	during func(t *testing.T, f *testFlow)
+	// concurrent inquiries for the same ID, one when zero
+	concurrent int
+
+	wantStatus int
+	// minLatency and maxLatency bound how long every inquiry takes, maxLatency being ignored when zero
+	minLatency time.Duration
+	maxLatency time.Duration
+	// wantProduced is how many messages the inquiries should have produced to kafka, unchecked when zero
+	wantProduced int
+}
+
+type e2eResult struct {
+	resp *http.Response
+	body []byte
+	took time.Duration
+	err  error
+}
+
+func TestInquiryContract(t *testing.T) {
+	delay := func(min, max time.Duration) func(c *Config) {
+		return func(c *Config) {
+			c.Consumer.DelayMin = min
+			c.Consumer.DelayMax = max
+		}
+	}
+
+	cases := []e2eCase{
+		{
+			name:         "success",
+			wantStatus:   http.StatusOK,
+			maxLatency:   time.Second,
+			wantProduced: 1,
+		},
+		{
+			name: "timeout",
+			configure: func(c *Config) {
+				c.Inquiry.Timeout = 200 * time.Millisecond
+				delay(500*time.Millisecond, 500*time.Millisecond)(c)
+			},
+			wantStatus: http.StatusGatewayTimeout,
+			minLatency: 200 * time.Millisecond,
+			maxLatency: 450 * time.Millisecond,
+		},
+		{
+			name:       "delay between delayMin and delayMax",
+			configure:  delay(150*time.Millisecond, 250*time.Millisecond),
+			wantStatus: http.StatusOK,
+			minLatency: 150 * time.Millisecond,
+			maxLatency: time.Second,
+		},
+		{
+			name:       "fixed delay when delayMin equals delayMax",
+			configure:  delay(150*time.Millisecond, 150*time.Millisecond),
+			wantStatus: http.StatusOK,
+			minLatency: 150 * time.Millisecond,
+			maxLatency: time.Second,
+		},
+		{
+			name:      "stale response skipped",
+			configure: delay(100*time.Millisecond, 100*time.Millisecond),
+			during: func(t *testing.T, f *testFlow) {
+				stale, err := json.Marshal(ResponseMessage{ID: "42", ReplyTo: f.waiting(t), Timestamp: time.Now().Add(-time.Hour)})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n := f.redis.Publish(cfg.Redis.Channel, stale).Val(); n != 1 {
+					t.Fatalf("stale response reached %d subscribers", n)
+				}
+			},
+			wantStatus: http.StatusOK,
+			minLatency: 100 * time.Millisecond,
+			maxLatency: time.Second,
+		},
+		{
+			name:         "concurrent duplicate IDs",
+			configure:    delay(100*time.Millisecond, 100*time.Millisecond),
+			concurrent:   10,
+			wantStatus:   http.StatusOK,
+			maxLatency:   time.Second,
+			wantProduced: 1,
+		},
+		{
+			// Each request waits for the response to its own kafka message
+			name: "concurrent duplicate IDs without coalescing",
+			configure: func(c *Config) {
+				delay(100*time.Millisecond, 100*time.Millisecond)(c)
+				c.Cache.Coalesce = false
+			},
+			concurrent:   10,
+			wantStatus:   http.StatusOK,
+			maxLatency:   time.Second,
+			wantProduced: 10,
+		},
+		{
+			name: "consumer restart with the inquiry queued",
+			before: func(t *testing.T, f *testFlow) {
+				f.warmUp(t)
+				f.stopConsumer()
+			},
+			during:       func(t *testing.T, f *testFlow) { f.startConsumer(t) },
+			wantStatus:   http.StatusOK,
+			maxLatency:   time.Second,
+			wantProduced: 1,
+		},
+		{
+			name:         "consumer restart while processing",
+			configure:    delay(100*time.Millisecond, 100*time.Millisecond),
+			before:       func(t *testing.T, f *testFlow) { f.warmUp(t) },
+			during:       func(t *testing.T, f *testFlow) { f.stopConsumer(); f.startConsumer(t) },
+			wantStatus:   http.StatusOK,
+			maxLatency:   time.Second,
+			wantProduced: 1,
+		},
+		{
+			name:       "kafka refusing the inquiry",
+			before:     func(t *testing.T, f *testFlow) { f.broker.FailProduce(errors.New("queue full")) },
+			wantStatus: http.StatusServiceUnavailable,
+			maxLatency: 250 * time.Millisecond,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) { runE2E(t, tc) })
+	}
+}
+
+func runE2E(t *testing.T, tc e2eCase) {
+	f := startFlow(t, tc.configure)
+	defer f.close(t)
+	if tc.before != nil {
+		tc.before(t, f)
+	}
+
+	n := tc.concurrent
+	if n == 0 {
+		n = 1
+	}
+	start := time.Now()
+	base := f.produced()
+	results := make(chan e2eResult, n)
+	for i := 0; i < n; i++ {
+		go func() { results <- f.get("42") }()
+	}
+	if tc.during != nil {
+		f.waitFor(t, "the inquiry to reach kafka", func() bool { return f.produced() > base })
+		tc.during(t, f)
+	}
+
+	for i := 0; i < n; i++ {
+		r := <-results
+		if r.err != nil {
+			t.Error(r.err)
+			continue
+		}
+		if r.resp.StatusCode != tc.wantStatus {
+			t.Errorf("status = %d, want %d, body %s", r.resp.StatusCode, tc.wantStatus, r.body)
+			continue
+		}
+		if r.took < tc.minLatency || (tc.maxLatency > 0 && r.took > tc.maxLatency) {
+			t.Errorf("took %s, want between %s and %s", r.took, tc.minLatency, tc.maxLatency)
+		}
+		checkBody(t, r, "42", start)
+	}
+	if produced := f.produced() - base; tc.wantProduced > 0 && produced != tc.wantProduced {
+		t.Errorf("%d messages produced, want %d", produced, tc.wantProduced)
+	}
+}
+
+// checkBody asserts the body is exactly one document: the fresh response of the ID, or a problem matching the status.
+func checkBody(t *testing.T, r e2eResult, id string, sent time.Time) {
+	t.Helper()
+	dec := json.NewDecoder(bytes.NewReader(r.body))
+	contentType := r.resp.Header.Get("Content-Type")
+
+	if r.resp.StatusCode == http.StatusOK {
+		if !strings.HasPrefix(contentType, "application/json") {
+			t.Errorf("Content-Type = %q", contentType)
+		}
+		var res ResponseMessage
+		if err := dec.Decode(&res); err != nil {
+			t.Fatalf("%v, body %s", err, r.body)
+		}
+		if res.ID != id {
+			t.Errorf("response of %q, want %q", res.ID, id)
+		}
+		if res.Timestamp.Before(sent) {
+			t.Errorf("response from %s is older than the inquiry", res.Timestamp)
+		}
+	} else {
+		if contentType != ProblemContentType {
+			t.Errorf("Content-Type = %q, want %q", contentType, ProblemContentType)
+		}
+		var p problem
+		if err := dec.Decode(&p); err != nil {
+			t.Fatalf("%v, body %s", err, r.body)
+		}
+		if p.Status != r.resp.StatusCode {
+			t.Errorf("problem status %d, answered with %d", p.Status, r.resp.StatusCode)
+		}
+	}
+
+	if dec.More() {
+		t.Errorf("body holds more than one document: %s", r.body)
+	}
+}
+
+// get is inquire for use outside of the test goroutine, reporting failures instead of stopping the test.
+func (f *testFlow) get(id string) e2eResult {
+	start := time.Now()
+	resp, err := http.Get(f.server.URL + "/inquiry/" + id)
+	if err != nil {
+		return e2eResult{err: err}
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return e2eResult{resp: resp, body: body, took: time.Since(start), err: err}
+}
+
+// produced tells how many messages went to the inquiry topic.
+func (f *testFlow) produced() int {
+	var n int
+	for p := int32(0); p < FakePartitions; p++ {
+		n += len(f.broker.Messages(cfg.Kafka.Topic, p))
+	}
+	return n
+}
+
+// warmUp sends one inquiry per partition, as a consumer that has been running would have committed an offset
+// on each and resumes from there after a restart.
+func (f *testFlow) warmUp(t *testing.T) {
+	t.Helper()
+	for i := 0; i < FakePartitions; i++ {
+		if resp, body := f.inquire(t, fmt.Sprintf("warm-%d", i)); resp.StatusCode != http.StatusOK {
+			t.Fatalf("warm up status = %d, body %s", resp.StatusCode, body)
+		}
+	}
+}
+
+// waiting returns the ReplyTo of the one request waiting for its response.
+func (f *testFlow) waiting(t *testing.T) string {
+	t.Helper()
+	inqMapMutex.RLock()
+	defer inqMapMutex.RUnlock()
+	if len(inqWaitMap) != 1 {
+		t.Fatalf("%d requests waiting, want 1", len(inqWaitMap))
+	}
+	for replyTo := range inqWaitMap {
+		return replyTo
+	}
+	return ""
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// FakeSubscriptionBuffer is how many messages a subscriber may fall behind before it's disconnected,
+// like redis' client output buffer limit
+const FakeSubscriptionBuffer = 1000
+
+var (
+	errFakeRedisClosed = errors.New("redis: client is closed")
+	errFakeNoScripts   = errors.New("fake redis: scripts aren't supported")
+)
+
+// fakeRedis is an in-memory redis: string keys expiring after their TTL and pub/sub channels. Scripts fail,
+// which callers are expected to survive, e.g. the redis rate limiter falling back to the local one.
+type fakeRedis struct {
+	mu   sync.Mutex
+	data map[string]fakeEntry
+	subs map[string]map[*fakeSubscription]bool
+	// err fails every command when set, as an unreachable redis would
+	err error
+}
+
+type fakeEntry struct {
+	value   string
+	expires time.Time
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{data: map[string]fakeEntry{}, subs: map[string]map[*fakeSubscription]bool{}}
+}
+
+// fail makes every command fail with err and drops the subscriptions, until called again with nil.
+func (r *fakeRedis) fail(err error) {
+	r.mu.Lock()
+	r.err = err
+	var dropped []*fakeSubscription
+	if err != nil {
+		for _, subs := range r.subs {
+			for s := range subs {
+				dropped = append(dropped, s)
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	for _, s := range dropped {
+		s.Close()
+	}
+}
+
+// subscribers tells how many subscriptions the channel has.
+func (r *fakeRedis) subscribers(channel string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.subs[channel])
+}
+
+// getLocked reads a key, forgetting it once expired.
+func (r *fakeRedis) getLocked(key string) (fakeEntry, bool) {
+	e, ok := r.data[key]
+	if ok && !e.expires.IsZero() && !time.Now().Before(e.expires) {
+		delete(r.data, key)
+		return fakeEntry{}, false
+	}
+	return e, ok
+}
+
+func (r *fakeRedis) Ping() *redis.StatusCmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return redis.NewStatusResult("", r.err)
+	}
+	return redis.NewStatusResult("PONG", nil)
+}
+
+func (r *fakeRedis) Get(key string) *redis.StringCmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return redis.NewStringResult("", r.err)
+	}
+	e, ok := r.getLocked(key)
+	if !ok {
+		return redis.NewStringResult("", redis.Nil)
+	}
+	return redis.NewStringResult(e.value, nil)
+}
+
+func (r *fakeRedis) Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return redis.NewStatusResult("", r.err)
+	}
+
+	e := fakeEntry{value: fakeString(value)}
+	if expiration > 0 {
+		e.expires = time.Now().Add(expiration)
+	}
+	r.data[key] = e
+	return redis.NewStatusResult("OK", nil)
+}
+
+// PTTL answers -2ms for a missing key and -1ms for one without expiry, as go-redis reads redis' replies.
+func (r *fakeRedis) PTTL(key string) *redis.DurationCmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return redis.NewDurationResult(0, r.err)
+	}
+	e, ok := r.getLocked(key)
+	switch {
+	case !ok:
+		return redis.NewDurationResult(-2*time.Millisecond, nil)
+	case e.expires.IsZero():
+		return redis.NewDurationResult(-time.Millisecond, nil)
+	}
+	return redis.NewDurationResult(time.Until(e.expires).Truncate(time.Millisecond), nil)
+}
+
+// Publish hands the message to every subscriber of the channel, disconnecting those too far behind.
+func (r *fakeRedis) Publish(channel string, message interface{}) *redis.IntCmd {
+	r.mu.Lock()
+	if r.err != nil {
+		r.mu.Unlock()
+		return redis.NewIntResult(0, r.err)
+	}
+	var n int64
+	var slow []*fakeSubscription
+	msg := &redis.Message{Channel: channel, Payload: fakeString(message)}
+	for s := range r.subs[channel] {
+		select {
+		case s.msgs <- msg:
+			n++
+		default:
+			slow = append(slow, s)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, s := range slow {
+		s.Close()
+	}
+	return redis.NewIntResult(n, nil)
+}
+
+func (r *fakeRedis) Eval(script string, keys []string, args ...interface{}) *redis.Cmd {
+	return redis.NewCmdResult(nil, errFakeNoScripts)
+}
+
+func (r *fakeRedis) EvalSha(sha1 string, keys []string, args ...interface{}) *redis.Cmd {
+	return redis.NewCmdResult(nil, errFakeNoScripts)
+}
+
+func (r *fakeRedis) ScriptExists(hashes ...string) *redis.BoolSliceCmd {
+	return redis.NewBoolSliceResult(nil, errFakeNoScripts)
+}
+
+func (r *fakeRedis) ScriptLoad(script string) *redis.StringCmd {
+	return redis.NewStringResult("", errFakeNoScripts)
+}
+
+// SubscribeChannel subscribes right away, confirming it as the first message received. While redis fails
+// the subscription is born closed.
+func (r *fakeRedis) SubscribeChannel(channel string) redisSubscription {
+	s := &fakeSubscription{r: r, channel: channel, msgs: make(chan interface{}, FakeSubscriptionBuffer), closed: make(chan struct{})}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		s.once.Do(func() { close(s.closed) })
+		return s
+	}
+	if r.subs[channel] == nil {
+		r.subs[channel] = map[*fakeSubscription]bool{}
+	}
+	r.subs[channel][s] = true
+	s.msgs <- &redis.Subscription{Kind: "subscribe", Channel: channel, Count: 1}
+	return s
+}
+
+type fakeSubscription struct {
+	r       *fakeRedis
+	channel string
+	msgs    chan interface{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// ReceiveTimeout returns the next message, or a timeout net.Error like go-redis does.
+func (s *fakeSubscription) ReceiveTimeout(timeout time.Duration) (interface{}, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case msg := <-s.msgs:
+		return msg, nil
+	case <-s.closed:
+		return nil, errFakeRedisClosed
+	case <-timer.C:
+		return nil, fakeTimeoutError{}
+	}
+}
+
+func (s *fakeSubscription) Ping(payload ...string) error {
+	select {
+	case <-s.closed:
+		return errFakeRedisClosed
+	default:
+	}
+
+	pong := &redis.Pong{}
+	if len(payload) > 0 {
+		pong.Payload = payload[0]
+	}
+	select {
+	case s.msgs <- pong:
+	default:
+	}
+	return nil
+}
+
+func (s *fakeSubscription) Close() error {
+	s.r.mu.Lock()
+	delete(s.r.subs[s.channel], s)
+	s.r.mu.Unlock()
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+type fakeTimeoutError struct{}
+
+func (fakeTimeoutError) Error() string   { return "i/o timeout" }
+func (fakeTimeoutError) Timeout() bool   { return true }
+func (fakeTimeoutError) Temporary() bool { return true }
+
+func fakeString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
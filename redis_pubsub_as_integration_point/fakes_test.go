@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func TestFakeRedisExpiry(t *testing.T) {
+	r := newFakeRedis()
+	r.Set("k", []byte("v"), 20*time.Millisecond)
+	if v, err := r.Get("k").Result(); err != nil || v != "v" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+	if ttl := r.PTTL("k").Val(); ttl <= 0 || ttl > 20*time.Millisecond {
+		t.Errorf("PTTL = %s", ttl)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, err := r.Get("k").Result(); err != redis.Nil {
+		t.Errorf("Get after expiry: %v, want redis.Nil", err)
+	}
+	if ttl := r.PTTL("k").Val(); ttl != -2*time.Millisecond {
+		t.Errorf("PTTL after expiry = %s", ttl)
+	}
+}
+
+func TestFakeRedisPubSub(t *testing.T) {
+	r := newFakeRedis()
+	ps := r.SubscribeChannel("c")
+	if msg, err := ps.ReceiveTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	} else if sub, ok := msg.(*redis.Subscription); !ok || sub.Channel != "c" {
+		t.Fatalf("first message %v, want the subscription", msg)
+	}
+
+	if n := r.Publish("c", "hello").Val(); n != 1 {
+		t.Errorf("published to %d subscribers", n)
+	}
+	if msg, err := ps.ReceiveTimeout(time.Second); err != nil || msg.(*redis.Message).Payload != "hello" {
+		t.Errorf("received %v, %v", msg, err)
+	}
+
+	_, err := ps.ReceiveTimeout(10 * time.Millisecond)
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("silent subscription returned %v, want a timeout", err)
+	}
+	if err := ps.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := ps.ReceiveTimeout(time.Second); msg == nil {
+		t.Error("no pong")
+	}
+
+	r.fail(errors.New("redis down"))
+	if _, err := ps.ReceiveTimeout(time.Second); err == nil {
+		t.Error("subscription survived redis failing")
+	}
+	if err := r.Ping().Err(); err == nil {
+		t.Error("ping succeeded while failing")
+	}
+}
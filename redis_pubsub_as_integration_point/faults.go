@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/amura2406/inquiry-kafka-redis-poc/internal/config"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Points where faults are injected
+	FaultProduce = "produce"
+	FaultConsume = "consume"
+	FaultPublish = "publish"
+
+	// Faults injected into a message
+	FaultDrop      = "drop"
+	FaultDuplicate = "duplicate"
+	FaultReorder   = "reorder"
+	FaultCorrupt   = "corrupt"
+	FaultDelay     = "delay"
+)
+
+var faults = newFaultInjector()
+
+// faultInjector decides which fault, if any, hits each message. Its rules can be replaced at runtime.
+type faultInjector struct {
+	mu    sync.Mutex
+	rules FaultConfig
+	rng   *rand.Rand
+}
+
+func newFaultInjector() *faultInjector {
+	return &faultInjector{rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func initFaults() {
+	if !cfg.Faults.Enabled {
+		return
+	}
+	faults.set(cfg.Faults)
+	log.WithFields(faults.fields()).Warn("Fault injection is enabled")
+}
+
+func (f *faultInjector) set(rules FaultConfig) {
+	f.mu.Lock()
+	f.rules = rules
+	f.mu.Unlock()
+}
+
+func (f *faultInjector) get() FaultConfig {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rules
+}
+
+func (f *faultInjector) fields() log.Fields {
+	fields := log.Fields{}
+	rules := f.get()
+	for point, tree := range config.Tree(reflect.ValueOf(&rules).Elem()) {
+		if point != "enabled" {
+			fields[point] = tree
+		}
+	}
+	return fields
+}
+
+// decide rolls once for the message: the probabilities of the point's faults are stacked, the roll landing in
+// one of them or past all of them for no fault. It returns the fault along with how long to delay the message,
+// or to hold it for reordering.
+func (f *faultInjector) decide(point string) (string, time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var r FaultRules
+	switch point {
+	case FaultProduce:
+		r = f.rules.Produce
+	case FaultConsume:
+		r = f.rules.Consume
+	case FaultPublish:
+		r = f.rules.Publish
+	}
+
+	roll := f.rng.Float64()
+	fault := ""
+	for _, p := range []struct {
+		fault       string
+		probability float64
+	}{{FaultDrop, r.Drop}, {FaultDuplicate, r.Duplicate}, {FaultReorder, r.Reorder}, {FaultCorrupt, r.Corrupt}, {FaultDelay, r.Delay}} {
+		if roll < p.probability {
+			fault = p.fault
+			break
+		}
+		roll -= p.probability
+	}
+
+	if fault == "" {
+		return "", 0
+	}
+	faultsInjectedTotal.WithLabelValues(point, fault).Inc()
+	if fault == FaultDelay {
+		d := r.DelayMin
+		if Δ := int64(r.DelayMax - r.DelayMin); Δ > 0 {
+			d += time.Duration(f.rng.Int63n(Δ))
+		}
+		return fault, d
+	}
+	return fault, r.ReorderWindow
+}
+
+// corrupt returns a copy cut short at a random length, which no longer decodes.
+func (f *faultInjector) corrupt(b []byte) []byte {
+	if len(b) == 0 {
+		return b
+	}
+	f.mu.Lock()
+	n := f.rng.Intn(len(b))
+	f.mu.Unlock()
+	return append([]byte(nil), b[:n]...)
+}
+
+// reorderBuffer holds back one message until the next one has passed it, or until its window elapses.
+type reorderBuffer struct {
+	mu   sync.Mutex
+	send func()
+	gen  int
+}
+
+func (b *reorderBuffer) hold(send func(), window time.Duration) {
+	// Only one message is held at a time, the previous one goes first
+	b.release()
+
+	b.mu.Lock()
+	b.send = send
+	b.gen++
+	gen := b.gen
+	b.mu.Unlock()
+
+	time.AfterFunc(window, func() {
+		b.mu.Lock()
+		if b.gen != gen {
+			b.mu.Unlock()
+			return
+		}
+		send := b.send
+		b.send = nil
+		b.mu.Unlock()
+		if send != nil {
+			send()
+		}
+	})
+}
+
+func (b *reorderBuffer) release() {
+	b.mu.Lock()
+	send := b.send
+	b.send = nil
+	b.gen++
+	b.mu.Unlock()
+	if send != nil {
+		send()
+	}
+}
+
+func cloneMessage(msg *kafka.Message) *kafka.Message {
+	clone := *msg
+	clone.Value = append([]byte(nil), msg.Value...)
+	return &clone
+}
+
+// faultyProducer injects faults between the http server and kafka.
+type faultyProducer struct {
+	kafkaProducer
+	held reorderBuffer
+}
+
+func withProduceFaults(p kafkaProducer) kafkaProducer {
+	if !cfg.Faults.Enabled {
+		return p
+	}
+	return &faultyProducer{kafkaProducer: p}
+}
+
+func (p *faultyProducer) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+	fault, d := faults.decide(FaultProduce)
+	switch fault {
+	case FaultDrop:
+		// Acknowledged yet lost, like a leader failing before the followers caught up
+		if deliveryChan != nil {
+			go func() { deliveryChan <- msg }()
+		}
+		return nil
+	case FaultDuplicate:
+		p.produceDetached(cloneMessage(msg))
+	case FaultCorrupt:
+		msg = cloneMessage(msg)
+		msg.Value = faults.corrupt(msg.Value)
+	case FaultDelay:
+		time.Sleep(d)
+	case FaultReorder:
+		p.held.hold(func() {
+			if err := p.kafkaProducer.Produce(msg, deliveryChan); err != nil && deliveryChan != nil {
+				msg.TopicPartition.Error = err
+				deliveryChan <- msg
+			}
+		}, d)
+		return nil
+	}
+
+	err := p.kafkaProducer.Produce(msg, deliveryChan)
+	p.held.release()
+	return err
+}
+
+// produceDetached produces a message nobody waits for, draining its delivery report.
+func (p *faultyProducer) produceDetached(msg *kafka.Message) {
+	delivery := make(chan kafka.Event, 1)
+	if err := p.kafkaProducer.Produce(msg, delivery); err == nil {
+		go func() { <-delivery }()
+	}
+}
+
+// faultyConsumer injects faults between kafka and the consumer's processing. ReadMessage is called from a
+// single goroutine, like the consume loop does.
+type faultyConsumer struct {
+	kafkaConsumer
+	// pending are duplicated or overtaken messages, returned before reading on
+	pending []*kafka.Message
+}
+
+func withConsumeFaults(c kafkaConsumer) kafkaConsumer {
+	if !cfg.Faults.Enabled {
+		return c
+	}
+	return &faultyConsumer{kafkaConsumer: c}
+}
+
+func (c *faultyConsumer) ReadMessage(timeout time.Duration) (*kafka.Message, error) {
+	if len(c.pending) > 0 {
+		msg := c.pending[0]
+		c.pending = c.pending[1:]
+		return msg, nil
+	}
+
+	for {
+		msg, err := c.kafkaConsumer.ReadMessage(timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		fault, d := faults.decide(FaultConsume)
+		switch fault {
+		case FaultDrop:
+			// Its offset is committed all the same
+			continue
+		case FaultDuplicate:
+			c.pending = append(c.pending, cloneMessage(msg))
+		case FaultCorrupt:
+			msg = cloneMessage(msg)
+			msg.Value = faults.corrupt(msg.Value)
+		case FaultDelay:
+			time.Sleep(d)
+		case FaultReorder:
+			next, err := c.kafkaConsumer.ReadMessage(d)
+			if err != nil {
+				// Nothing came to overtake it
+				return msg, nil
+			}
+			c.pending = append(c.pending, msg)
+			return next, nil
+		}
+		return msg, nil
+	}
+}
+
+// faultyRedis injects faults between the consumer and the response channel.
+type faultyRedis struct {
+	redisClient
+	held reorderBuffer
+}
+
+func withPublishFaults(c redisClient) redisClient {
+	if !cfg.Faults.Enabled {
+		return c
+	}
+	return &faultyRedis{redisClient: c}
+}
+
+func (c *faultyRedis) Publish(channel string, message interface{}) *redis.IntCmd {
+	fault, d := faults.decide(FaultPublish)
+	switch fault {
+	case FaultDrop:
+		return redis.NewIntResult(0, nil)
+	case FaultDuplicate:
+		c.redisClient.Publish(channel, message)
+	case FaultCorrupt:
+		message = string(faults.corrupt([]byte(fmt.Sprint(message))))
+	case FaultDelay:
+		time.Sleep(d)
+	case FaultReorder:
+		c.held.hold(func() { c.redisClient.Publish(channel, message) }, d)
+		return redis.NewIntResult(0, nil)
+	}
+
+	cmd := c.redisClient.Publish(channel, message)
+	c.held.release()
+	return cmd
+}
+
+// addFaultRoutes serves the fault rules on GET, replaces them on PUT with the given points, the others
+// injecting nothing, and clears them all on DELETE. Only authenticated principals the policy grants the faults
+// route may use it, so it isn't served without -auth.
+func addFaultRoutes(r *mux.Router) {
+	if !cfg.Faults.Enabled {
+		return
+	}
+	if !cfg.Auth.Enabled {
+		log.Warn("Not serving /faults without authentication, the faults of the config can't be changed")
+		return
+	}
+	r.Handle("/faults", authenticateHTTP(http.HandlerFunc(faultsHandler))).Methods("GET", "PUT", "DELETE").Name("faults")
+}
+
+func faultsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "PUT":
+		rules, err := parseFaultRules(r)
+		if err != nil {
+			writeError(w, r, &errBadInput{err.Error()})
+			return
+		}
+		faults.set(rules)
+		log.WithFields(faults.fields()).Warn("Fault injection changed")
+	case "DELETE":
+		faults.set(noFaults())
+		log.Warn("Fault injection cleared")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(faults.fields())
+}
+
+// noFaults are the enabled rules injecting nothing.
+func noFaults() FaultConfig {
+	return FaultConfig{Enabled: true, Produce: defaultFaultRules(), Consume: defaultFaultRules(), Publish: defaultFaultRules()}
+}
+
+func parseFaultRules(r *http.Request) (FaultConfig, error) {
+	rules := noFaults()
+
+	var raw map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		return rules, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if _, ok := raw["enabled"]; ok {
+		return rules, fmt.Errorf("fault injection can't be turned off at runtime, clear the faults with DELETE")
+	}
+	if err := config.SetFromMap(reflect.ValueOf(&rules).Elem(), "faults.", raw); err != nil {
+		return rules, err
+	}
+	return rules, rules.validate()
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withFaults enables fault injection with the point's rules adjusted by set, inquiries timing out after 300ms.
+func withFaults(point string, set func(r *FaultRules)) func(c *Config) {
+	return func(c *Config) {
+		c.Inquiry.Timeout = 300 * time.Millisecond
+		c.Faults.Enabled = true
+		switch point {
+		case FaultProduce:
+			set(&c.Faults.Produce)
+		case FaultConsume:
+			set(&c.Faults.Consume)
+		case FaultPublish:
+			set(&c.Faults.Publish)
+		}
+	}
+}
+
+func TestInquiryUnderFaults(t *testing.T) {
+	cases := []e2eCase{
+		{
+			name:       "dropped at produce",
+			configure:  withFaults(FaultProduce, func(r *FaultRules) { r.Drop = 1 }),
+			wantStatus: http.StatusGatewayTimeout,
+			minLatency: 300 * time.Millisecond,
+		},
+		{
+			name:       "dropped at consume",
+			configure:  withFaults(FaultConsume, func(r *FaultRules) { r.Drop = 1 }),
+			wantStatus: http.StatusGatewayTimeout,
+			minLatency: 300 * time.Millisecond,
+		},
+		{
+			name:       "dropped at publish",
+			configure:  withFaults(FaultPublish, func(r *FaultRules) { r.Drop = 1 }),
+			wantStatus: http.StatusGatewayTimeout,
+			minLatency: 300 * time.Millisecond,
+		},
+		{
+			name:       "corrupted at publish",
+			configure:  withFaults(FaultPublish, func(r *FaultRules) { r.Corrupt = 1 }),
+			wantStatus: http.StatusGatewayTimeout,
+			minLatency: 300 * time.Millisecond,
+		},
+		{
+			// Held back longer than kafka.produceTimeout, the flow is kept until it's released
+			name: "reordered at produce",
+			configure: func(c *Config) {
+				withFaults(FaultProduce, func(r *FaultRules) { r.Reorder = 1; r.ReorderWindow = 200 * time.Millisecond })(c)
+				c.Kafka.ProduceTimeout = 100 * time.Millisecond
+			},
+			during:     func(t *testing.T, f *testFlow) {},
+			wantStatus: http.StatusServiceUnavailable,
+			minLatency: 100 * time.Millisecond,
+			maxLatency: 250 * time.Millisecond,
+		},
+		{
+			name:         "duplicated at produce",
+			configure:    withFaults(FaultProduce, func(r *FaultRules) { r.Duplicate = 1 }),
+			wantStatus:   http.StatusOK,
+			maxLatency:   250 * time.Millisecond,
+			wantProduced: 2,
+		},
+		{
+			name:       "duplicated at consume",
+			configure:  withFaults(FaultConsume, func(r *FaultRules) { r.Duplicate = 1 }),
+			wantStatus: http.StatusOK,
+			maxLatency: 250 * time.Millisecond,
+		},
+		{
+			name:       "duplicated at publish",
+			configure:  withFaults(FaultPublish, func(r *FaultRules) { r.Duplicate = 1 }),
+			wantStatus: http.StatusOK,
+			maxLatency: 250 * time.Millisecond,
+		},
+		{
+			// Nothing overtakes it, it's released once its window elapses
+			name:       "reordered at publish",
+			configure:  withFaults(FaultPublish, func(r *FaultRules) { r.Reorder = 1; r.ReorderWindow = 50 * time.Millisecond }),
+			wantStatus: http.StatusOK,
+			minLatency: 50 * time.Millisecond,
+			maxLatency: 250 * time.Millisecond,
+		},
+		{
+			name: "delayed at consume",
+			configure: withFaults(FaultConsume, func(r *FaultRules) {
+				r.Delay = 1
+				r.DelayMin = 100 * time.Millisecond
+				r.DelayMax = 150 * time.Millisecond
+			}),
+			wantStatus: http.StatusOK,
+			minLatency: 100 * time.Millisecond,
+			maxLatency: 250 * time.Millisecond,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) { runE2E(t, tc) })
+	}
+}
+
+func TestConsumerSurvivesCorruptMessages(t *testing.T) {
+	f := startFlow(t, withFaults(FaultConsume, func(r *FaultRules) { r.Corrupt = 1 }))
+	defer f.close(t)
+
+	if resp, body := f.inquire(t, "42"); resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d with corrupt messages, body %s", resp.StatusCode, body)
+	}
+	faults.set(noFaults())
+	if resp, body := f.inquire(t, "42"); resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d once the faults are cleared, body %s", resp.StatusCode, body)
+	}
+}
+
+func TestFaultsEndpoint(t *testing.T) {
+	f := startFlow(t, func(c *Config) {
+		withFaults(FaultPublish, func(r *FaultRules) {})(c)
+		c.Auth.Enabled = true
+		c.Auth.APIKeys = map[string]string{"ops": "k-ops", "app": "k-app"}
+		c.Auth.Policy = map[string]string{"ops": "faults:*", PolicyAnyPrincipal: "*:*"}
+	})
+	defer f.close(t)
+
+	do := func(method, path, key, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, f.server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != "" {
+			req.Header.Set(cfg.Auth.APIKeyHeader, key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	call := func(method, body string) (int, map[string]map[string]interface{}) {
+		t.Helper()
+		resp := do(method, "/faults", "k-ops", body)
+		defer resp.Body.Close()
+		var rules map[string]map[string]interface{}
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&rules); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, rules
+	}
+	inquire := func() int {
+		t.Helper()
+		resp := do("GET", "/inquiry/42", "k-app", "")
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Only principals the policy names the faults route for may change them, * rules don't grant it
+	for key, want := range map[string]int{"": http.StatusUnauthorized, "k-app": http.StatusForbidden} {
+		resp := do("PUT", "/faults", key, `{"publish": {"drop": 1}}`)
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("PUT with key %q answered %d, want %d", key, resp.StatusCode, want)
+		}
+	}
+
+	status, rules := call("PUT", `{"publish": {"drop": 1, "delayMax": "2s"}}`)
+	if status != http.StatusOK || rules[FaultPublish]["drop"] != 1.0 || rules[FaultPublish]["delayMax"] != "2s" {
+		t.Fatalf("PUT answered %d, %v", status, rules)
+	}
+	if status := inquire(); status != http.StatusGatewayTimeout {
+		t.Errorf("status = %d with responses dropped", status)
+	}
+
+	for _, body := range []string{`{"publish": {"drop": 0.6, "corrupt": 0.6}}`, `{"enabled": false}`, `{"nowhere": {}}`, `not json`} {
+		if status, _ := call("PUT", body); status != http.StatusBadRequest {
+			t.Errorf("PUT %s answered %d, want 400", body, status)
+		}
+	}
+
+	if status, rules := call("DELETE", ""); status != http.StatusOK || rules[FaultPublish]["drop"] != 0.0 {
+		t.Fatalf("DELETE answered %d, %v", status, rules)
+	}
+	if status := inquire(); status != http.StatusOK {
+		t.Errorf("status = %d once the faults are cleared", status)
+	}
+}
+
+func TestFaultsEndpointNeedsFaultsAndAuth(t *testing.T) {
+	for name, configure := range map[string]func(c *Config){
+		"without fault injection": func(c *Config) {
+			c.Auth.Enabled = true
+			c.Auth.APIKeys = map[string]string{"ops": "k-ops"}
+		},
+		"without authentication": withFaults(FaultPublish, func(r *FaultRules) {}),
+	} {
+		f := startFlow(t, configure)
+		resp, err := http.Get(f.server.URL + "/faults")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("status = %d %s, want 404", resp.StatusCode, name)
+		}
+		f.close(t)
+	}
+}
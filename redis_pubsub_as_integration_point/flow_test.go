@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amura2406/inquiry-kafka-redis-poc/internal/kafkatest"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	log "github.com/sirupsen/logrus"
+	"github.com/sony/gobreaker"
+)
+
+// FakePartitions is the number of partitions of the fake topics
+const FakePartitions = 3
+
+// testFlow runs the http server and the consumer in-process, talking through a fake kafka and redis.
+type testFlow struct {
+	broker *kafkatest.Broker
+	redis  *fakeRedis
+	server *httptest.Server
+
+	done            chan struct{}
+	consumerDone    chan struct{}
+	consumerStopped chan struct{}
+}
+
+// startFlow starts the flow with the default config, adjusted by configure when given. The consumer
+// answers right away unless configured otherwise.
+func startFlow(t *testing.T, configure func(c *Config)) *testFlow {
+	t.Helper()
+	log.SetLevel(log.WarnLevel)
+
+	cfg = defaultConfig()
+	cfg.Inquiry.Timeout = 2 * time.Second
+	cfg.Consumer.DelayMin = 0
+	cfg.Consumer.DelayMax = 0
+	if configure != nil {
+		configure(&cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	initDelays()
+
+	f := &testFlow{broker: kafkatest.NewBroker(FakePartitions), redis: newFakeRedis(), done: make(chan struct{})}
+	initFaults()
+	redisCli = withPublishFaults(f.redis)
+	producer = withProduceFaults(f.broker.NewProducer())
+	f.startConsumer(t)
+	f.server = httptest.NewUnstartedServer(newGateway(f.done))
+	f.server.Config.WriteTimeout = cfg.HTTP.WriteTimeout
+	f.server.Start()
+
+	f.waitFor(t, "the response subscription", func() bool {
+		ps, _ := currentSubscription()
+		return ps != nil
+	})
+	return f
+}
+
+// startConsumer joins a new consumer to the group, resuming from the group's committed offsets.
+func (f *testFlow) startConsumer(t *testing.T) {
+	t.Helper()
+	c := f.broker.NewConsumer(cfg.Kafka.ConsumerGroup, kafka.OffsetEnd)
+	if err := c.SubscribeTopics([]string{cfg.Kafka.Topic}, nil); err != nil {
+		t.Fatal(err)
+	}
+	consumer = withConsumeFaults(c)
+	f.consumerDone = make(chan struct{})
+	f.consumerStopped = make(chan struct{})
+	go func(c kafkaConsumer, done, stopped chan struct{}) {
+		consume(c, done)
+		close(stopped)
+	}(consumer, f.consumerDone, f.consumerStopped)
+}
+
+// stopConsumer stops reading, returning once the messages being processed got their response.
+func (f *testFlow) stopConsumer() {
+	if f.consumerDone != nil {
+		close(f.consumerDone)
+		<-f.consumerStopped
+		f.consumerDone = nil
+	}
+}
+
+// close stops everything, the next flow may start once the subscription is given up.
+func (f *testFlow) close(t *testing.T) {
+	t.Helper()
+	f.server.Close()
+	f.stopConsumer()
+	close(f.done)
+	f.waitFor(t, "the subscription to end", func() bool {
+		ps, _ := currentSubscription()
+		return ps == nil
+	})
+}
+
+func (f *testFlow) waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// inquire gets /inquiry/{id}, returning the response with its body read.
+func (f *testFlow) inquire(t *testing.T, id string) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.Get(f.server.URL + "/inquiry/" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestFlowRequestReply(t *testing.T) {
+	f := startFlow(t, nil)
+	defer f.close(t)
+
+	resp, body := f.inquire(t, "42")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+	}
+	var res ResponseMessage
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.ID != "42" || res.Partial {
+		t.Errorf("response = %+v, want the final one of 42", res)
+	}
+
+	// The inquiry went through kafka once, keyless messages being spread round robin
+	if produced := f.produced(); produced != 1 {
+		t.Errorf("%d messages produced, want 1", produced)
+	}
+}
+
+// The caller's deadline running out before inquiry.timeout isn't a failure of the backend.
+func TestCallerDeadlineIsNotATimeout(t *testing.T) {
+	f := startFlow(t, func(c *Config) {
+		c.Consumer.DelayMin = 300 * time.Millisecond
+		c.Consumer.DelayMax = 300 * time.Millisecond
+		c.Breaker.MinRequests = 1
+	})
+	defer f.close(t)
+
+	for _, id := range []string{"42", "43"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err := awaitInquiry(ctx, id)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("inquiry %s: %v, want the caller's deadline", id, err)
+		}
+	}
+	if s := responseBreaker.state(); s != gobreaker.StateClosed {
+		t.Errorf("response breaker %v after the caller gave up, want closed", s)
+	}
+}
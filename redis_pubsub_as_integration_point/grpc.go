@@ -0,0 +1,206 @@
+package main
+
+//go:generate protoc -I inquirypb --go_out=plugins=grpc:inquirypb inquirypb/inquiry.proto
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/amura2406/inquiry-kafka-redis-poc/redis_pubsub_as_integration_point/inquirypb"
+	"github.com/golang/protobuf/ptypes"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpccreds "google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type inquiryServer struct{}
+
+func startGrpcServer() {
+	lis, err := net.Listen("tcp", cfg.GRPC.Address)
+	if err != nil {
+		panic(err)
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(chainUnaryRPC(traceUnaryRPC, authUnaryRPC, rateLimitUnaryRPC)),
+		grpc.StreamInterceptor(chainStreamRPC(traceStreamRPC, authStreamRPC, rateLimitStreamRPC)),
+	}
+	// gRPC is served over the same TLS as http, never in the clear next to an HTTPS server
+	tlsOn := cfg.HTTP.TLSCert != "" || cfg.HTTP.TLSKey != ""
+	if tlsOn {
+		tlsConfig, err := buildTLSConfig()
+		if err != nil {
+			panic(err)
+		}
+		opts = append(opts, grpc.Creds(grpccreds.NewTLS(tlsConfig)))
+	}
+
+	s := grpc.NewServer(opts...)
+	inquirypb.RegisterInquiryServiceServer(s, &inquiryServer{})
+
+	log.WithField("addr", cfg.GRPC.Address).WithField("tls", tlsOn).WithField("mTLS", cfg.HTTP.TLSClientCA != "").Info("gRPC server is listening...")
+	if err := s.Serve(lis); err != nil {
+		panic(err)
+	}
+}
+
+// Inquire answers like GET /inquiry/{id}, from the cache or the final response, up to inquiry.timeout or the
+// caller's deadline.
+func (s *inquiryServer) Inquire(ctx context.Context, req *inquirypb.InquiryRequest) (*inquirypb.InquiryResponse, error) {
+	if err := validateID(req.Id); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	start := time.Now()
+	res, err := resolveInquiry(ctx, req.Id)
+	switch err {
+	case nil:
+	case errInquiryTimeout:
+		observeInquiry("grpc", OutcomeTimeout, start)
+		return nil, rpcError(ctx, err)
+	case context.Canceled, context.DeadlineExceeded:
+		observeInquiry("grpc", contextOutcome(ctx), start)
+		return nil, status.FromContextError(err).Err()
+	default:
+		observeInquiry("grpc", OutcomeError, start)
+		return nil, rpcError(ctx, err)
+	}
+
+	md := metadata.Pairs("x-cache", res.Source)
+	if res.Source == CacheStale {
+		md.Set("warning", StaleWarning)
+		md.Set("age", strconv.Itoa(int(res.Age.Seconds())))
+	}
+	grpc.SetHeader(ctx, md)
+	observeInquiry("grpc", OutcomeOK, start)
+	return toProto(res.Response)
+}
+
+// InquireStream sends every partial response followed by the final one, up to inquiry.timeout or the caller's
+// deadline.
+func (s *inquiryServer) InquireStream(req *inquirypb.InquiryRequest, stream inquirypb.InquiryService_InquireStreamServer) error {
+	if err := validateID(req.Id); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	start := time.Now()
+	ctx := stream.Context()
+
+	p, err := publishInquiry(ctx, req.Id, true, StreamBufferSize)
+	if err != nil {
+		observeInquiry("grpc_stream", OutcomeError, start)
+		return rpcError(ctx, err)
+	}
+	defer p.release()
+
+	timer := time.NewTimer(cfg.Inquiry.Timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case resp := <-p.Responses:
+			pbResp, err := toProto(resp)
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(pbResp); err != nil {
+				observeInquiry("grpc_stream", OutcomeGone, start)
+				return err
+			}
+			if !resp.Partial {
+				p.answered()
+				msgLogger(ctx).WithField("ID", resp.ID).WithField("Seq", resp.Seq).Info("Stream completed")
+				observeInquiry("grpc_stream", OutcomeOK, start)
+				return nil
+			}
+		case <-timer.C:
+			p.timedOut()
+			observeInquiry("grpc_stream", OutcomeTimeout, start)
+			return rpcError(ctx, errInquiryTimeout)
+		case <-ctx.Done():
+			// The caller giving up, its own deadline included, says nothing about the backend
+			observeInquiry("grpc_stream", contextOutcome(ctx), start)
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// rpcCodes are the gRPC counterparts of the problem types
+var rpcCodes = map[string]codes.Code{
+	ProblemTypePrefix + ProblemBadInput:    codes.InvalidArgument,
+	ProblemTypePrefix + ProblemOverloaded:  codes.ResourceExhausted,
+	ProblemTypePrefix + ProblemUnavailable: codes.Unavailable,
+	ProblemTypePrefix + ProblemTimeout:     codes.DeadlineExceeded,
+}
+
+// rpcError maps an inquiry error like problemFor does for http, telling the client when to retry if it's worth it.
+// Clients get the problem's detail, never the kafka or redis error behind it.
+func rpcError(ctx context.Context, err error) error {
+	p := problemFor(err)
+	if p.retryAfter > 0 {
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(int(math.Ceil(p.retryAfter.Seconds())))))
+	}
+	code, ok := rpcCodes[p.Type]
+	if !ok {
+		return status.Error(codes.Internal, "internal error")
+	}
+	return status.Error(code, p.Detail)
+}
+
+// chainUnaryRPC runs the interceptors in order, the server taking a single one.
+func chainUnaryRPC(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			next, interceptor := handler, interceptors[i]
+			handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// chainStreamRPC is chainUnaryRPC for streams.
+func chainStreamRPC(interceptors ...grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			next, interceptor := handler, interceptors[i]
+			handler = func(srv interface{}, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, next)
+			}
+		}
+		return handler(srv, ss)
+	}
+}
+
+// contextOutcome tells a deadline running out apart from the caller cancelling.
+func contextOutcome(ctx context.Context) string {
+	if ctx.Err() == context.DeadlineExceeded {
+		return OutcomeTimeout
+	}
+	return OutcomeGone
+}
+
+func toProto(resp *ResponseMessage) (*inquirypb.InquiryResponse, error) {
+	ts, err := ptypes.TimestampProto(resp.Timestamp)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "invalid timestamp: %v", err)
+	}
+
+	return &inquirypb.InquiryResponse{
+		Id:        resp.ID,
+		Name:      resp.Name,
+		Date:      resp.Date,
+		Currency:  resp.Currency,
+		Amount:    resp.Amount,
+		Seq:       int32(resp.Seq),
+		Partial:   resp.Partial,
+		Timestamp: ts,
+	}, nil
+}
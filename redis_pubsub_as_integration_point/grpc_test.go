@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRPCErrorHidesCause(t *testing.T) {
+	cases := []struct {
+		err  error
+		code codes.Code
+	}{
+		{&errBrokerUnavailable{errors.New("broker kafka-1:9092 refused the connection")}, codes.Unavailable},
+		{errors.New("dial tcp redis-0:6379: connection refused"), codes.Internal},
+	}
+	for _, c := range cases {
+		s := status.Convert(rpcError(context.Background(), c.err))
+		if s.Code() != c.code {
+			t.Errorf("%v: code %v, want %v", c.err, s.Code(), c.code)
+		}
+		if strings.Contains(s.Message(), "kafka-1") || strings.Contains(s.Message(), "redis-0") {
+			t.Errorf("%v: message %q leaks the cause", c.err, s.Message())
+		}
+	}
+}
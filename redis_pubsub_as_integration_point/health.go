@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// HealthCheckTimeout bounds every single check so a hanging dependency can't hang the probe
+	HealthCheckTimeout = 2 * time.Second
+	// KafkaMetadataTimeoutMs bounds the metadata request proving the broker is reachable
+	KafkaMetadataTimeoutMs = 1500
+
+	HealthOK   = "ok"
+	HealthDown = "down"
+)
+
+type healthCheck struct {
+	name  string
+	check func() error
+}
+
+type checkResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type checkOutcome struct {
+	name string
+	err  error
+}
+
+type healthInfo struct {
+	name string
+	info func() interface{}
+}
+
+type healthReport struct {
+	Status  string                 `json:"status"`
+	Checks  map[string]checkResult `json:"checks"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+var (
+	healthChecks   []healthCheck
+	healthInfos    []healthInfo
+	healthChecksMu sync.RWMutex
+)
+
+// addHealthCheck registers a dependency check reported by /healthz and gating /readyz.
+func addHealthCheck(name string, check func() error) {
+	healthChecksMu.Lock()
+	healthChecks = append(healthChecks, healthCheck{name: name, check: check})
+	healthChecksMu.Unlock()
+}
+
+// addHealthInfo registers state shown by the probes which doesn't affect readiness.
+func addHealthInfo(name string, info func() interface{}) {
+	healthChecksMu.Lock()
+	healthInfos = append(healthInfos, healthInfo{name: name, info: info})
+	healthChecksMu.Unlock()
+}
+
+// runHealthChecks runs every check concurrently, a check not answering in time counts as down.
+func runHealthChecks() healthReport {
+	healthChecksMu.RLock()
+	checks := append([]healthCheck(nil), healthChecks...)
+	infos := append([]healthInfo(nil), healthInfos...)
+	healthChecksMu.RUnlock()
+
+	report := healthReport{Status: HealthOK, Checks: make(map[string]checkResult, len(checks))}
+	if len(infos) > 0 {
+		report.Details = make(map[string]interface{}, len(infos))
+		for _, hi := range infos {
+			report.Details[hi.name] = hi.info()
+		}
+	}
+	results := make(chan checkOutcome, len(checks))
+
+	for _, hc := range checks {
+		go func(hc healthCheck) {
+			done := make(chan error, 1)
+			go func() { done <- hc.check() }()
+
+			var err error
+			select {
+			case err = <-done:
+			case <-time.After(HealthCheckTimeout):
+				err = fmt.Errorf("no answer within %s", HealthCheckTimeout)
+			}
+			results <- checkOutcome{name: hc.name, err: err}
+		}(hc)
+	}
+
+	for range checks {
+		res := <-results
+		if res.err != nil {
+			report.Status = HealthDown
+			report.Checks[res.name] = checkResult{Status: HealthDown, Error: res.err.Error()}
+		} else {
+			report.Checks[res.name] = checkResult{Status: HealthOK}
+		}
+	}
+	return report
+}
+
+// healthzHandler is the liveness probe: it reports the dependencies but answers 200 as long as the process
+// serves, restarting won't fix a broker or redis outage.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, runHealthChecks(), http.StatusOK)
+}
+
+// readyzHandler is the readiness probe: it answers 503 while any dependency is down so traffic goes elsewhere.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	report := runHealthChecks()
+	code := http.StatusOK
+	if report.Status != HealthOK {
+		code = http.StatusServiceUnavailable
+	}
+	writeHealth(w, report, code)
+}
+
+func writeHealth(w http.ResponseWriter, report healthReport, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(report)
+}
+
+func checkRedis() error {
+	return redisCli.Ping().Err()
+}
+
+// checkSubscription fails while the subscription is being restored, otherwise it pings over the subscribed
+// connection itself, which redis allows while subscribed.
+func checkSubscription() error {
+	ps, attempts := currentSubscription()
+	if ps == nil {
+		return fmt.Errorf("not subscribed, %d attempts so far", attempts)
+	}
+	return ps.Ping()
+}
+
+func checkProducer() error {
+	_, err := producer.GetMetadata(&cfg.Kafka.Topic, false, KafkaMetadataTimeoutMs)
+	return err
+}
+
+func checkConsumer() error {
+	_, err := consumer.GetMetadata(&cfg.Kafka.Topic, false, KafkaMetadataTimeoutMs)
+	return err
+}
+
+// checkAssignment fails until the group gave this consumer partitions, e.g. during a rebalance.
+func checkAssignment() error {
+	assignment, err := consumer.Assignment()
+	if err != nil {
+		return err
+	}
+	if len(assignment) == 0 {
+		return fmt.Errorf("no partition assigned")
+	}
+	return nil
+}
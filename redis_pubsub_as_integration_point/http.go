@@ -1,122 +1,249 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/amura2406/inquiry-kafka-redis-poc/redis_pubsub_as_integration_point/tracing"
 	"github.com/bxcodec/faker"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
-	"github.com/go-redis/redis"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 )
 
 var (
-	producer    *kafka.Producer
-	pubSub      *redis.PubSub
+	producer kafkaProducer
+	// inqWaitMap holds the channel of each request waiting for responses, by the ReplyTo of its inquiry
 	inqWaitMap  map[string](chan *ResponseMessage)
 	inqMapMutex sync.RWMutex
+
+	errProduceTimeout = errors.New("kafka didn't acknowledge the inquiry in time")
 )
 
 func StartHttpServer() {
-	inqWaitMap = make(map[string](chan *ResponseMessage))
-	inqMapMutex = sync.RWMutex{}
-
-	redisOpts := &redis.Options{
-		Addr:         redisAddress,
-		Password:     "", // no password set
-		DB:           0,  // use default DB
-		PoolSize:     1,
-		MinIdleConns: 1,
-		PoolTimeout:  1 * time.Second,
-	}
-
+	initFaults()
 	initProducer()
-	initRedis(redisOpts)
-	initPubsubRedis()
+	initRedis(cfg.Redis.HTTP)
 
-	r := mux.NewRouter()
-	r.HandleFunc("/inquiry/{id}", inquiry).Methods("GET")
+	r := newGateway(nil)
+	if cfg.GRPC.Address != "" {
+		go startGrpcServer()
+	}
 
-	log.Infof("HTTP server is listening...")
-	if err := http.ListenAndServe(":8080", r); err != nil {
+	if err := listenAndServe(r); err != nil {
 		panic(err)
 	}
 
-	log.Infof("Shutting down.")
+	log.Info("Shutting down")
 }
 
-func initProducer() {
-	log.WithField("topic", topic).Infof("Creating kafka producer")
+// newGateway sets up the http server around the producer and redis client and returns its routes.
+// The response subscription is kept until done is closed.
+func newGateway(done <-chan struct{}) *mux.Router {
+	inqWaitMap = make(map[string](chan *ResponseMessage))
+	inqMapMutex = sync.RWMutex{}
 
-	p, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": broker})
-	if err != nil {
-		panic(err)
-	}
+	initTracing("inquiry-http")
+	initAdmission()
+	initBreakers()
+	initAuth()
+	initRateLimit()
+	go superviseSubscription(done)
 
-	producer = p
+	addHealthCheck("redis", checkRedis)
+	addHealthCheck("redis_subscription", checkSubscription)
+	addHealthCheck("kafka_producer", checkProducer)
+
+	r := mux.NewRouter()
+	r.Handle("/metrics", metricsHandler()).Methods("GET")
+	r.HandleFunc("/healthz", healthzHandler).Methods("GET")
+	r.HandleFunc("/readyz", readyzHandler).Methods("GET")
+	addFaultRoutes(r)
+
+	// Probes and scrapes are kept out of traces and correlation
+	api := r.PathPrefix("/inquiry").Subrouter()
+	api.HandleFunc("/{id}", inquiry).Methods("GET").Name("inquiry")
+	api.HandleFunc("/{id}/stream", inquiryStream).Methods("GET").Name("stream")
+	api.HandleFunc("/{id}/ws", inquiryWebSocket).Methods("GET").Name("ws")
+	api.Use(correlate, traceHTTP, authenticateHTTP, rateLimit)
+
+	return r
 }
 
-func initPubsubRedis() {
-	pubSub = redisCli.Subscribe(redisChannel)
+func initProducer() {
+	log.WithField("topic", cfg.Kafka.Topic).Info("Creating kafka producer")
 
-	// Wait for confirmation that subscription is created before publishing anything.
-	_, err := pubSub.Receive()
+	p, err := kafka.NewProducer(kafkaConfig("producer", cfg.Kafka.Producer, kafka.ConfigMap{"bootstrap.servers": cfg.Kafka.Broker}))
 	if err != nil {
 		panic(err)
 	}
 
-	// Spawn goroutine to listen
-	go listenFromRedisChannel()
+	producer = withProduceFaults(p)
 }
 
 func inquiry(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	msgId := vars["id"]
+	start := time.Now()
 
-	mBytes, err := buildMessage(msgId)
+	if err := validateID(msgId); err != nil {
+		writeError(w, r, err)
+		observeInquiry("http", OutcomeError, start)
+		return
+	}
+
+	res, err := resolveInquiry(r.Context(), msgId)
+	switch err {
+	case nil:
+	case errInquiryTimeout:
+		writeError(w, r, err)
+		observeInquiry("http", OutcomeTimeout, start)
+		return
+	case context.Canceled, context.DeadlineExceeded:
+		observeInquiry("http", OutcomeGone, start)
+		return
+	default:
+		writeError(w, r, err)
+		observeInquiry("http", OutcomeError, start)
+		return
+	}
+
+	resBytes, err := json.Marshal(res.Response)
 	if err != nil {
 		panic(err)
 	}
+	w.Header().Set("X-Cache", res.Source)
+	switch res.Source {
+	case CacheHit:
+		w.Header().Set("Age", strconv.Itoa(int(res.Age.Seconds())))
+	case CacheStale:
+		w.Header().Set("Age", strconv.Itoa(int(res.Age.Seconds())))
+		w.Header().Set("Warning", StaleWarning)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resBytes)
+	observeInquiry("http", OutcomeOK, start)
+}
+
+// startInquiry publishes the inquiry to kafka and returns it pending its responses, writing a problem
+// and returning false when the ID is invalid or the message can't be delivered.
+func startInquiry(w http.ResponseWriter, r *http.Request, msgId string, stream bool, bufSize int) (*pendingInquiry, bool) {
+	err := validateID(msgId)
+	var p *pendingInquiry
+	if err == nil {
+		p, err = publishInquiry(r.Context(), msgId, stream, bufSize)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return nil, false
+	}
 
-	respCh := registerWaitChannel(msgId)
-	log.Infof("Publishing message [%s] to kafka", msgId)
-	err = pushToKafka(mBytes)
+	return p, true
+}
+
+// pendingInquiry is an inquiry delivered to kafka, its responses arrive on Responses.
+type pendingInquiry struct {
+	ID        string
+	ReplyTo   string
+	Responses chan *ResponseMessage
+	Delivered time.Time
+
+	admission *admission
+	settle    func(success bool)
+	once      sync.Once
+}
+
+// answered records the final response arriving, for the metrics and the admission limit.
+func (p *pendingInquiry) answered() {
+	redisWaitDuration.Observe(time.Since(p.Delivered).Seconds())
+	p.admission.answered()
+}
+
+// timedOut tells the response breaker and the admission limit that no response came in time.
+func (p *pendingInquiry) timedOut() {
+	p.admission.timedOut()
+	p.once.Do(func() { p.settle(false) })
+}
+
+// release unregisters the waiter and frees its admission slot. Unless it timed out the wait counts as a success
+// for the response breaker, a caller going away isn't redis' fault.
+func (p *pendingInquiry) release() {
+	unregisterWaitChannel(p.ReplyTo, p.Responses)
+	p.admission.release()
+	p.once.Do(func() { p.settle(true) })
+}
 
+// publishInquiry registers a waiter for the inquiry and delivers it to kafka, within a producer span.
+// It fails fast with errOverloaded beyond the admission limit and with errBreakerOpen while either circuit
+// breaker is open, a failed delivery is errBrokerUnavailable.
+func publishInquiry(ctx context.Context, msgId string, stream bool, bufSize int) (*pendingInquiry, error) {
+	ctx, span := tracing.Start(ctx, cfg.Kafka.Topic+" publish", tracing.KindProducer)
+	defer span.End()
+	span.SetAttribute("messaging.system", "kafka")
+	span.SetAttribute("messaging.destination", cfg.Kafka.Topic)
+	span.SetAttribute("inquiry.id", msgId)
+	span.SetAttribute("inquiry.stream", stream)
+
+	adm, err := admissionLimiter.acquire()
 	if err != nil {
-		log.Errorf("Delivery failed of [%s]: %v", msgId, err)
-		http.Error(w, "Can't publish to kafka !", 500)
-		return
+		span.SetError(err)
+		return nil, err
+	}
+	responseDone, err := responseBreaker.allow()
+	if err != nil {
+		adm.release()
+		span.SetError(err)
+		return nil, err
+	}
+	publishDone, err := publishBreaker.allow()
+	if err != nil {
+		// Nothing was learnt about redis, the unused probe counts as a success so a half-open breaker isn't left waiting
+		responseDone(true)
+		adm.release()
+		span.SetError(err)
+		return nil, err
 	}
-	log.Infof("Successfully delivered to kafka [%s]", msgId)
 
-	// Careful to use time.After since underlying Timer is not garbage collected
-	timeout := time.After(10 * time.Second)
+	replyTo := newCorrelationID()
+	mBytes, err := buildMessage(msgId, replyTo, stream)
+	if err != nil {
+		panic(err)
+	}
 
-	select {
-	case resp := <-respCh:
-		log.WithField("ID", resp.ID).WithField("Amount", resp.Amount).Infoln("Response received from redis")
+	respCh := registerWaitChannel(replyTo, bufSize)
+	msgLogger(ctx).WithField("ID", msgId).Info("Publishing message to kafka")
+	err = pushToKafka(ctx, mBytes)
+	// A caller going away tells nothing about kafka
+	publishDone(err == nil || err == context.Canceled)
 
-		resBytes, err := json.Marshal(resp)
-		if err != nil {
-			panic(err)
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(resBytes)
-	case <-timeout:
-		http.Error(w, "Too long waiting", 500)
+	if err != nil {
+		responseDone(true)
+		adm.release()
+		span.SetError(err)
+		unregisterWaitChannel(replyTo, respCh)
+		errorsTotal.WithLabelValues("kafka_publish").Inc()
+		logger(ctx).WithField("ID", msgId).WithError(err).Error("Delivery to kafka failed")
+		return nil, &errBrokerUnavailable{err}
 	}
+	msgLogger(ctx).WithField("ID", msgId).Info("Successfully delivered to kafka")
+
+	return &pendingInquiry{ID: msgId, ReplyTo: replyTo, Responses: respCh, Delivered: time.Now(), admission: adm, settle: responseDone}, nil
 }
 
-func buildMessage(id string) ([]byte, error) {
+func buildMessage(id, replyTo string, stream bool) ([]byte, error) {
 	message := RequestMessage{}
 	err := faker.FakeData(&message)
 	if err != nil {
 		return nil, err
 	}
 	message.ID = id
+	message.Stream = stream
+	message.ReplyTo = replyTo
 	message.Timestamp = time.Now()
 	mBytes, err := json.Marshal(message)
 	if err != nil {
@@ -126,62 +253,118 @@ func buildMessage(id string) ([]byte, error) {
 	return mBytes, nil
 }
 
-func registerWaitChannel(id string) chan *ResponseMessage {
-	ch := make(chan *ResponseMessage, 1)
+// registerWaitChannel sets up the channel the responses addressed to replyTo go to.
+func registerWaitChannel(replyTo string, bufSize int) chan *ResponseMessage {
+	ch := make(chan *ResponseMessage, bufSize)
 	inqMapMutex.Lock()
-	inqWaitMap[id] = ch
+	inqWaitMap[replyTo] = ch
 	inqMapMutex.Unlock()
 	return ch
 }
 
-func pushToKafka(msg []byte) error {
-	delivery := make(chan kafka.Event)
-	defer close(delivery)
+// unregisterWaitChannel removes the channel, once its request stopped waiting or got its final response.
+func unregisterWaitChannel(replyTo string, ch chan *ResponseMessage) {
+	inqMapMutex.Lock()
+	if inqWaitMap[replyTo] == ch {
+		delete(inqWaitMap, replyTo)
+	}
+	inqMapMutex.Unlock()
+}
+
+// pushToKafka delivers the message carrying the correlation id and trace context in its headers.
+func pushToKafka(ctx context.Context, msg []byte) error {
+	// Never closed, the delivery report may come after giving up on it
+	delivery := make(chan kafka.Event, 1)
 
-	_ = producer.Produce(&kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+	start := time.Now()
+	defer func() {
+		kafkaDeliveryDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	km := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &cfg.Kafka.Topic, Partition: kafka.PartitionAny},
 		Value:          msg,
-	}, delivery)
+	}
+	carrier := kafkaHeaderCarrier{msg: km}
+	tracing.Inject(ctx, carrier)
+	if id := correlationID(ctx); id != "" {
+		carrier.Set(CorrelationKafkaHeader, id)
+	}
+	if p := principalFrom(ctx); p != nil {
+		carrier.Set(PrincipalKafkaHeader, p.Name)
+		carrier.Set(AuthMethodKafkaHeader, p.Method)
+	}
+	if err := producer.Produce(km, delivery); err != nil {
+		return err
+	}
 
-	ev := <-delivery
-	km := ev.(*kafka.Message)
+	timer := time.NewTimer(cfg.Kafka.ProduceTimeout)
+	defer timer.Stop()
+	select {
+	case ev := <-delivery:
+		km = ev.(*kafka.Message)
+	case <-timer.C:
+		return errProduceTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 
 	if km.TopicPartition.Error != nil {
 		return km.TopicPartition.Error
 	}
+	tracing.SpanFromContext(ctx).SetAttribute("messaging.kafka.partition", km.TopicPartition.Partition)
 	return nil
 }
 
-func listenFromRedisChannel() {
-	log.WithField("Channel", redisChannel).Info("Start subscribing to redis")
-	// Go channel which receives messages.
-	ch := pubSub.Channel()
-
-	for msg := range ch {
-		resp := ResponseMessage{}
-		err := json.Unmarshal([]byte(msg.Payload), &resp)
-		if err != nil {
-			log.Errorf("JSON Parse Error: %v\n", err)
-			continue
-		}
-
-		tenSecsAgo := time.Now().Add(-10 * time.Second)
-		if resp.Timestamp.Before(tenSecsAgo) {
-			log.WithField("ID", resp.ID).WithField("Timestamp", resp.Timestamp).Debugf("SKIP message: too long ago")
-			continue
-		}
-
-		inqMapMutex.RLock()
-		ch := inqWaitMap[resp.ID]
-		inqMapMutex.RUnlock()
-		if ch == nil {
-			log.WithField("ID", resp.ID).Debugf("SKIP message: not relevant")
-			continue
-		}
-
-		ch <- &resp
-		inqMapMutex.Lock()
-		delete(inqWaitMap, resp.ID)
-		inqMapMutex.Unlock()
+// handleResponse routes a response published by the consumer to the inquiry waiting for it.
+func handleResponse(payload string) {
+	resp := ResponseMessage{}
+	err := json.Unmarshal([]byte(payload), &resp)
+	if err != nil {
+		errorsTotal.WithLabelValues("redis_decode").Inc()
+		log.WithError(err).Error("Can't decode response from redis")
+		return
+	}
+
+	staleBefore := time.Now().Add(-cfg.Inquiry.StaleAfter)
+	if resp.Timestamp.Before(staleBefore) {
+		staleSkippedTotal.WithLabelValues("http").Inc()
+		logger(withCorrelationID(context.Background(), resp.CorrelationID)).
+			WithField("ID", resp.ID).WithField("Timestamp", resp.Timestamp).Debug("SKIP message: too long ago")
+		return
+	}
+
+	inqMapMutex.RLock()
+	ch := inqWaitMap[resp.ReplyTo]
+	inqMapMutex.RUnlock()
+	if ch == nil {
+		logger(withCorrelationID(context.Background(), resp.CorrelationID)).
+			WithField("ID", resp.ID).Debug("SKIP message: not relevant")
+		return
+	}
+
+	dispatchResponse(ch, &resp)
+}
+
+// dispatchResponse hands the response to its waiter within a span continuing the consumer's trace.
+func dispatchResponse(ch chan *ResponseMessage, resp *ResponseMessage) {
+	ctx := tracing.ExtractTraceParent(withCorrelationID(context.Background(), resp.CorrelationID), resp.TraceParent)
+	ctx, span := tracing.Start(ctx, cfg.Redis.Channel+" receive", tracing.KindConsumer)
+	defer span.End()
+	span.SetAttribute("messaging.system", "redis")
+	span.SetAttribute("messaging.destination", cfg.Redis.Channel)
+	span.SetAttribute("inquiry.id", resp.ID)
+	span.SetAttribute("inquiry.seq", resp.Seq)
+	span.SetAttribute("inquiry.partial", resp.Partial)
+
+	select {
+	case ch <- resp:
+	default:
+		responsesDroppedTotal.Inc()
+		span.SetAttribute("inquiry.dropped", true)
+		logger(ctx).WithField("ID", resp.ID).WithField("Seq", resp.Seq).Warn("SKIP message: waiter is not keeping up")
+	}
+	if !resp.Partial {
+		unregisterWaitChannel(resp.ReplyTo, ch)
 	}
 }
@@ -0,0 +1,323 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: inquiry.proto
+
+package inquirypb
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+import timestamp "github.com/golang/protobuf/ptypes/timestamp"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type InquiryRequest struct {
+	Id                   string   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *InquiryRequest) Reset()         { *m = InquiryRequest{} }
+func (m *InquiryRequest) String() string { return proto.CompactTextString(m) }
+func (*InquiryRequest) ProtoMessage()    {}
+func (*InquiryRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_inquiry_32767a44a997ec9f, []int{0}
+}
+func (m *InquiryRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_InquiryRequest.Unmarshal(m, b)
+}
+func (m *InquiryRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_InquiryRequest.Marshal(b, m, deterministic)
+}
+func (dst *InquiryRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_InquiryRequest.Merge(dst, src)
+}
+func (m *InquiryRequest) XXX_Size() int {
+	return xxx_messageInfo_InquiryRequest.Size(m)
+}
+func (m *InquiryRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_InquiryRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_InquiryRequest proto.InternalMessageInfo
+
+func (m *InquiryRequest) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+type InquiryResponse struct {
+	Id                   string               `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name                 string               `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Date                 string               `protobuf:"bytes,3,opt,name=date,proto3" json:"date,omitempty"`
+	Currency             string               `protobuf:"bytes,4,opt,name=currency,proto3" json:"currency,omitempty"`
+	Amount               float64              `protobuf:"fixed64,5,opt,name=amount,proto3" json:"amount,omitempty"`
+	Seq                  int32                `protobuf:"varint,6,opt,name=seq,proto3" json:"seq,omitempty"`
+	Partial              bool                 `protobuf:"varint,7,opt,name=partial,proto3" json:"partial,omitempty"`
+	Timestamp            *timestamp.Timestamp `protobuf:"bytes,8,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
+	XXX_unrecognized     []byte               `json:"-"`
+	XXX_sizecache        int32                `json:"-"`
+}
+
+func (m *InquiryResponse) Reset()         { *m = InquiryResponse{} }
+func (m *InquiryResponse) String() string { return proto.CompactTextString(m) }
+func (*InquiryResponse) ProtoMessage()    {}
+func (*InquiryResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_inquiry_32767a44a997ec9f, []int{1}
+}
+func (m *InquiryResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_InquiryResponse.Unmarshal(m, b)
+}
+func (m *InquiryResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_InquiryResponse.Marshal(b, m, deterministic)
+}
+func (dst *InquiryResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_InquiryResponse.Merge(dst, src)
+}
+func (m *InquiryResponse) XXX_Size() int {
+	return xxx_messageInfo_InquiryResponse.Size(m)
+}
+func (m *InquiryResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_InquiryResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_InquiryResponse proto.InternalMessageInfo
+
+func (m *InquiryResponse) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *InquiryResponse) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *InquiryResponse) GetDate() string {
+	if m != nil {
+		return m.Date
+	}
+	return ""
+}
+
+func (m *InquiryResponse) GetCurrency() string {
+	if m != nil {
+		return m.Currency
+	}
+	return ""
+}
+
+func (m *InquiryResponse) GetAmount() float64 {
+	if m != nil {
+		return m.Amount
+	}
+	return 0
+}
+
+func (m *InquiryResponse) GetSeq() int32 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
+func (m *InquiryResponse) GetPartial() bool {
+	if m != nil {
+		return m.Partial
+	}
+	return false
+}
+
+func (m *InquiryResponse) GetTimestamp() *timestamp.Timestamp {
+	if m != nil {
+		return m.Timestamp
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*InquiryRequest)(nil), "inquiry.InquiryRequest")
+	proto.RegisterType((*InquiryResponse)(nil), "inquiry.InquiryResponse")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// InquiryServiceClient is the client API for InquiryService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type InquiryServiceClient interface {
+	// Inquire waits for the final response of an inquiry.
+	Inquire(ctx context.Context, in *InquiryRequest, opts ...grpc.CallOption) (*InquiryResponse, error)
+	// InquireStream sends every partial response followed by the final one.
+	InquireStream(ctx context.Context, in *InquiryRequest, opts ...grpc.CallOption) (InquiryService_InquireStreamClient, error)
+}
+
+type inquiryServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewInquiryServiceClient(cc *grpc.ClientConn) InquiryServiceClient {
+	return &inquiryServiceClient{cc}
+}
+
+func (c *inquiryServiceClient) Inquire(ctx context.Context, in *InquiryRequest, opts ...grpc.CallOption) (*InquiryResponse, error) {
+	out := new(InquiryResponse)
+	err := c.cc.Invoke(ctx, "/inquiry.InquiryService/Inquire", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *inquiryServiceClient) InquireStream(ctx context.Context, in *InquiryRequest, opts ...grpc.CallOption) (InquiryService_InquireStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_InquiryService_serviceDesc.Streams[0], "/inquiry.InquiryService/InquireStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &inquiryServiceInquireStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type InquiryService_InquireStreamClient interface {
+	Recv() (*InquiryResponse, error)
+	grpc.ClientStream
+}
+
+type inquiryServiceInquireStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *inquiryServiceInquireStreamClient) Recv() (*InquiryResponse, error) {
+	m := new(InquiryResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// InquiryServiceServer is the server API for InquiryService service.
+type InquiryServiceServer interface {
+	// Inquire waits for the final response of an inquiry.
+	Inquire(context.Context, *InquiryRequest) (*InquiryResponse, error)
+	// InquireStream sends every partial response followed by the final one.
+	InquireStream(*InquiryRequest, InquiryService_InquireStreamServer) error
+}
+
+func RegisterInquiryServiceServer(s *grpc.Server, srv InquiryServiceServer) {
+	s.RegisterService(&_InquiryService_serviceDesc, srv)
+}
+
+func _InquiryService_Inquire_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InquiryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InquiryServiceServer).Inquire(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/inquiry.InquiryService/Inquire",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InquiryServiceServer).Inquire(ctx, req.(*InquiryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InquiryService_InquireStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(InquiryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(InquiryServiceServer).InquireStream(m, &inquiryServiceInquireStreamServer{stream})
+}
+
+type InquiryService_InquireStreamServer interface {
+	Send(*InquiryResponse) error
+	grpc.ServerStream
+}
+
+type inquiryServiceInquireStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *inquiryServiceInquireStreamServer) Send(m *InquiryResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _InquiryService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "inquiry.InquiryService",
+	HandlerType: (*InquiryServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Inquire",
+			Handler:    _InquiryService_Inquire_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "InquireStream",
+			Handler:       _InquiryService_InquireStream_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "inquiry.proto",
+}
+
+func init() { proto.RegisterFile("inquiry.proto", fileDescriptor_inquiry_32767a44a997ec9f) }
+
+var fileDescriptor_inquiry_32767a44a997ec9f = []byte{
+	// 287 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x90, 0x3f, 0x4f, 0xf3, 0x30,
+	0x10, 0xc6, 0x5f, 0xf7, 0x5f, 0xda, 0xab, 0xda, 0x17, 0xdd, 0x00, 0x56, 0x16, 0xac, 0x4e, 0x99,
+	0x52, 0x54, 0x16, 0x26, 0x06, 0x06, 0x24, 0x56, 0x97, 0x89, 0xcd, 0x4d, 0x8e, 0xca, 0x52, 0x13,
+	0x27, 0x8e, 0x83, 0xd4, 0x6f, 0xc2, 0xf7, 0xe3, 0x8b, 0xa0, 0xc4, 0x49, 0x10, 0xb0, 0xb1, 0xdd,
+	0xf3, 0xdc, 0xcf, 0x96, 0xee, 0x07, 0x2b, 0x9d, 0x97, 0xb5, 0xb6, 0xe7, 0xb8, 0xb0, 0xc6, 0x19,
+	0x0c, 0xba, 0x18, 0x5e, 0x1f, 0x8d, 0x39, 0x9e, 0x68, 0xdb, 0xd6, 0x87, 0xfa, 0x75, 0xeb, 0x74,
+	0x46, 0x95, 0x53, 0x59, 0xe1, 0xc9, 0x8d, 0x80, 0xf5, 0x93, 0x67, 0x25, 0x95, 0x35, 0x55, 0x0e,
+	0xd7, 0x30, 0xd2, 0x29, 0x67, 0x82, 0x45, 0x0b, 0x39, 0xd2, 0xe9, 0xe6, 0x83, 0xc1, 0xff, 0x01,
+	0xa9, 0x0a, 0x93, 0x57, 0xf4, 0x93, 0x41, 0x84, 0x49, 0xae, 0x32, 0xe2, 0xa3, 0xb6, 0x69, 0xe7,
+	0xa6, 0x4b, 0x95, 0x23, 0x3e, 0xf6, 0x5d, 0x33, 0x63, 0x08, 0xf3, 0xa4, 0xb6, 0x96, 0xf2, 0xe4,
+	0xcc, 0x27, 0x6d, 0x3f, 0x64, 0xbc, 0x84, 0x99, 0xca, 0x4c, 0x9d, 0x3b, 0x3e, 0x15, 0x2c, 0x62,
+	0xb2, 0x4b, 0x78, 0x01, 0xe3, 0x8a, 0x4a, 0x3e, 0x13, 0x2c, 0x9a, 0xca, 0x66, 0x44, 0x0e, 0x41,
+	0xa1, 0xac, 0xd3, 0xea, 0xc4, 0x03, 0xc1, 0xa2, 0xb9, 0xec, 0x23, 0xde, 0xc1, 0x62, 0x38, 0x90,
+	0xcf, 0x05, 0x8b, 0x96, 0xbb, 0x30, 0xf6, 0x0a, 0xe2, 0x5e, 0x41, 0xfc, 0xdc, 0x13, 0xf2, 0x0b,
+	0xde, 0xbd, 0xb3, 0x41, 0xc4, 0x9e, 0xec, 0x9b, 0x4e, 0x08, 0xef, 0x21, 0xf0, 0x0d, 0xe1, 0x55,
+	0xdc, 0xfb, 0xfd, 0x2e, 0x2b, 0xe4, 0xbf, 0x17, 0x5e, 0xd1, 0xe6, 0x1f, 0x3e, 0xc2, 0xaa, 0x7b,
+	0xbf, 0x77, 0x96, 0x54, 0xf6, 0xa7, 0x5f, 0x6e, 0xd8, 0xc3, 0xf2, 0x65, 0xd1, 0xad, 0x8b, 0xc3,
+	0x61, 0xd6, 0x9e, 0x71, 0xfb, 0x39, 0x00, 0xdc, 0x8d, 0x39, 0xba, 0xf1, 0x01, 0x00, 0x00,
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// JWTLeeway absorbs clock skew with the issuer when checking exp and nbf
+	JWTLeeway = 30 * time.Second
+	// JWKSReloadInterval is how often the JWKS file is checked for rotated keys
+	JWKSReloadInterval = 30 * time.Second
+)
+
+// jwks holds the public keys of a JSON Web Key Set file by key id, reloading them when the file changes.
+type jwks struct {
+	path string
+
+	mu      sync.RWMutex
+	keys    map[string]crypto.PublicKey
+	modTime time.Time
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func newJWKS(path string) (*jwks, error) {
+	k := &jwks{path: path}
+	if err := k.load(); err != nil {
+		return nil, err
+	}
+	go k.watch()
+	return k, nil
+}
+
+func (k *jwks) load() error {
+	fi, err := os.Stat(k.path)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(k.path)
+	if err != nil {
+		return err
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return fmt.Errorf("%s: %v", k.path, err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		pub, err := jwk.publicKey()
+		if err != nil {
+			return fmt.Errorf("%s: key %q: %v", k.path, jwk.Kid, err)
+		}
+		keys[jwk.Kid] = pub
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("%s: no signing key found", k.path)
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.modTime = fi.ModTime()
+	k.mu.Unlock()
+	return nil
+}
+
+// watch reloads the keys whenever the file is modified, keeping the previous ones if the new file is broken.
+func (k *jwks) watch() {
+	for range time.Tick(JWKSReloadInterval) {
+		fi, err := os.Stat(k.path)
+		k.mu.RLock()
+		changed := err == nil && !fi.ModTime().Equal(k.modTime)
+		k.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err := k.load(); err != nil {
+			errorsTotal.WithLabelValues("jwks_reload").Inc()
+			log.WithError(err).Error("Can't reload the JWKS, keeping the previous keys")
+			continue
+		}
+		log.WithField("file", k.path).Info("JWKS reloaded")
+	}
+}
+
+// key finds the key a token was signed with, a token without kid is accepted when the set holds a single key.
+func (k *jwks) key(kid string) (crypto.PublicKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if kid == "" && len(k.keys) == 1 {
+		for _, pub := range k.keys {
+			return pub, true
+		}
+	}
+	pub, ok := k.keys[kid]
+	return pub, ok
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point isn't on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// jwtAuth accepts bearer tokens signed by a key of the JWKS, with RS, PS or ES algorithms. The principal is
+// auth.principalClaim, the issuer and audience are checked when configured.
+type jwtAuth struct {
+	keys *jwks
+}
+
+func (a *jwtAuth) authenticate(c credentials) (*principal, error) {
+	authz := c.get("Authorization")
+	if !strings.HasPrefix(authz, "Bearer ") {
+		return nil, errNoCredentials
+	}
+
+	claims, err := a.verify(strings.TrimSpace(authz[len("Bearer "):]))
+	if err != nil {
+		return nil, err
+	}
+
+	name, _ := claims[cfg.Auth.PrincipalClaim].(string)
+	if name == "" {
+		return nil, fmt.Errorf("token has no %s claim", cfg.Auth.PrincipalClaim)
+	}
+	return &principal{Name: name, Method: AuthJWT}, nil
+}
+
+// verify checks the signature and the registered claims of a compact JWS, returning its claims.
+func (a *jwtAuth) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %v", err)
+	}
+
+	pub, ok := a.keys.key(header.Kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", header.Kid)
+	}
+	if err := verifySignature(header.Alg, pub, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %v", err)
+	}
+	return claims, checkClaims(claims)
+}
+
+func verifySignature(alg string, pub crypto.PublicKey, signed string, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+	if hash == 0 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		var err error
+		switch alg[:2] {
+		case "RS":
+			err = rsa.VerifyPKCS1v15(key, hash, digest, sig)
+		case "PS":
+			err = rsa.VerifyPSS(key, hash, digest, sig, nil)
+		default:
+			return fmt.Errorf("algorithm %s doesn't match an RSA key", alg)
+		}
+		if err != nil {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(sig) != 2*size {
+			return fmt.Errorf("algorithm %s doesn't match an EC key", alg)
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	default:
+		return errors.New("unsupported key")
+	}
+}
+
+// checkClaims requires an unexpired token, valid already, from the configured issuer and for the audience.
+func checkClaims(claims map[string]interface{}) error {
+	now := time.Now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token has no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(JWTLeeway)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(JWTLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token not valid yet")
+	}
+
+	if cfg.Auth.JWTIssuer != "" && claims["iss"] != cfg.Auth.JWTIssuer {
+		return fmt.Errorf("unexpected issuer %v", claims["iss"])
+	}
+	if cfg.Auth.JWTAudience != "" && !hasAudience(claims["aud"], cfg.Auth.JWTAudience) {
+		return fmt.Errorf("token isn't meant for %s", cfg.Auth.JWTAudience)
+	}
+	return nil
+}
+
+// hasAudience handles aud being a single string or an array of them.
+func hasAudience(aud interface{}, want string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == want
+	case []interface{}:
+		for _, a := range aud {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
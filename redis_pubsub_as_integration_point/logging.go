@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/amura2406/inquiry-kafka-redis-poc/redis_pubsub_as_integration_point/tracing"
+	colorable "github.com/mattn/go-colorable"
+	isatty "github.com/mattn/go-isatty"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+
+	// CorrelationHeader carries the correlation id on http requests and responses, and as gRPC metadata
+	CorrelationHeader = "X-Correlation-ID"
+	// RequestIDHeader is accepted as correlation id when CorrelationHeader is absent
+	RequestIDHeader = "X-Request-ID"
+	// CorrelationKafkaHeader carries the correlation id to the consumer
+	CorrelationKafkaHeader = "correlation-id"
+)
+
+type correlationKey struct{}
+
+// sampledOut swallows per-message lines of inquiries which aren't sampled.
+var sampledOut = &log.Logger{
+	Out:       ioutil.Discard,
+	Formatter: new(log.TextFormatter),
+	Hooks:     make(log.LevelHooks),
+	Level:     log.PanicLevel,
+}
+
+// initLogging applies the log settings, colors are only used when stdout is a terminal.
+func initLogging() {
+	level, err := log.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		panic(err)
+	}
+	log.SetLevel(level)
+
+	if cfg.Log.Format == LogFormatJSON {
+		log.SetOutput(os.Stdout)
+		log.SetFormatter(&log.JSONFormatter{})
+		return
+	}
+
+	tty := isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
+	if tty {
+		// Windows consoles need the escape sequences translated
+		log.SetOutput(colorable.NewColorableStdout())
+	} else {
+		log.SetOutput(os.Stdout)
+	}
+	log.SetFormatter(&log.TextFormatter{
+		FullTimestamp: true,
+		ForceColors:   tty,
+		DisableColors: !tty,
+	})
+}
+
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func withCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+func correlationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// logger returns an entry carrying the correlation and trace ids and the principal found in the context.
+func logger(ctx context.Context) *log.Entry {
+	entry := log.NewEntry(log.StandardLogger())
+	if id := correlationID(ctx); id != "" {
+		entry = entry.WithField("correlationID", id)
+	}
+	if p := principalFrom(ctx); p != nil {
+		entry = entry.WithField("principal", p.Name)
+	}
+	if span := tracing.SpanFromContext(ctx); span != nil {
+		entry = entry.WithField("traceID", span.Context().TraceID.String())
+	}
+	return entry
+}
+
+// msgLogger is logger for lines written for every message, only one inquiry in log.sampleRate writes them
+// unless debug logging is on. The decision hangs on the correlation id so both sub commands keep the same inquiries.
+func msgLogger(ctx context.Context) *log.Entry {
+	if cfg.Log.SampleRate <= 1 || log.IsLevelEnabled(log.DebugLevel) {
+		return logger(ctx)
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(correlationID(ctx)))
+	if h.Sum32()%uint32(cfg.Log.SampleRate) != 0 {
+		return log.NewEntry(sampledOut)
+	}
+	return logger(ctx)
+}
+
+// correlate picks up the caller's correlation id, or makes one, and echoes it on the response.
+func correlate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(CorrelationHeader)
+		if id == "" {
+			id = r.Header.Get(RequestIDHeader)
+		}
+		if id == "" {
+			id = newCorrelationID()
+		}
+
+		w.Header().Set(CorrelationHeader, id)
+		next.ServeHTTP(w, r.WithContext(withCorrelationID(r.Context(), id)))
+	})
+}
@@ -4,30 +4,31 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/go-redis/redis"
-	colorable "github.com/mattn/go-colorable"
+	"github.com/amura2406/inquiry-kafka-redis-poc/internal/config"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
 	log "github.com/sirupsen/logrus"
 )
 
 var (
-	broker        string
-	topic         string
-	consumerGroup string
-	redisAddress  string
-	redisChannel  string
-	asyncConsume  bool
-	delayMin      time.Duration
-	delayMax      time.Duration
-	redisCli      *redis.Client
+	cfg        = defaultConfig()
+	configFile string
+	dumpFormat string
+	redisCli   redisClient
 )
 
 type RequestMessage struct {
 	ID        string `faker:"username"`
 	Name      string `faker:"name"`
 	Date      string `faker:"date"`
+	Stream    bool   `faker:"-"`
 	Timestamp time.Time
+	// ReplyTo is unique to the request waiting for the response, which echoes it back, so concurrent inquiries
+	// for the same ID each get their own responses
+	ReplyTo string `faker:"-" json:",omitempty"`
 }
 
 type ResponseMessage struct {
@@ -36,60 +37,159 @@ type ResponseMessage struct {
 	Date      string  `faker:"date"`
 	Currency  string  `faker:"currency"`
 	Amount    float64 `faker:"amount"`
+	Seq       int     `faker:"-"`
+	Partial   bool    `faker:"-"`
 	Timestamp time.Time
-}
-
-func init() {
-	log.SetOutput(colorable.NewColorableStdout())
+	// TraceParent and CorrelationID travel back in the payload since redis pub/sub has no headers
+	TraceParent   string `faker:"-" json:",omitempty"`
+	CorrelationID string `faker:"-" json:",omitempty"`
+	ReplyTo       string `faker:"-" json:",omitempty"`
 }
 
 func main() {
 	consumerSubCmd := flag.NewFlagSet("consumer", flag.ExitOnError)
 	httpSubCmd := flag.NewFlagSet("http", flag.ExitOnError)
+	configSubCmd := flag.NewFlagSet("config", flag.ExitOnError)
+
+	addCommonFlags(consumerSubCmd)
+	addConsumerFlags(consumerSubCmd)
+	consumerSubCmd.Var(config.Properties(cfg.Kafka.Consumer), "X", "librdkafka consumer property as key=value, can be repeated")
 
-	consumerSubCmd.StringVar(&broker, "broker", "localhost", "Kafka broker address")
-	consumerSubCmd.StringVar(&topic, "topic", "poc-test", "Name of the topic")
-	consumerSubCmd.StringVar(&consumerGroup, "cg", "testCG", "Name of the Kafka consumer group")
-	consumerSubCmd.StringVar(&redisAddress, "redisAddr", "localhost:6379", "Redis address")
-	consumerSubCmd.StringVar(&redisChannel, "redisChan", "inquiry-response", "Redis channel to listen")
-	consumerSubCmd.DurationVar(&delayMin, "minD", 0*time.Second, "Minimum synthetic delay duration")
-	consumerSubCmd.DurationVar(&delayMax, "maxD", 0*time.Second, "Maximum synthetic delay duration")
-	consumerSubCmd.BoolVar(&asyncConsume, "async", true, "Whether to process each message from kafka asynchronously or not")
+	addCommonFlags(httpSubCmd)
+	addHttpFlags(httpSubCmd)
+	httpSubCmd.Var(config.Properties(cfg.Kafka.Producer), "X", "librdkafka producer property as key=value, can be repeated")
 
-	httpSubCmd.StringVar(&broker, "broker", "localhost", "Kafka broker address")
-	httpSubCmd.StringVar(&topic, "topic", "poc-test", "Name of the topic")
-	httpSubCmd.StringVar(&redisAddress, "redisAddr", "localhost:6379", "Redis address")
-	httpSubCmd.StringVar(&redisChannel, "redisChan", "inquiry-response", "Redis channel to listen")
+	addCommonFlags(configSubCmd)
+	addConsumerFlags(configSubCmd)
+	addHttpFlags(configSubCmd)
+	configSubCmd.Var(config.Properties(cfg.Kafka.Properties), "X", "librdkafka property of both roles as key=value, can be repeated")
+	configSubCmd.StringVar(&dumpFormat, "format", "yaml", "Output format of config dump: yaml, toml or json")
 
 	if len(os.Args) < 2 {
-		fmt.Println("consumer or http sub command is required !")
+		fmt.Println("consumer, http or config sub command is required !")
 		os.Exit(1)
 	}
 
+	var err error
 	switch os.Args[1] {
 	case "consumer":
-		consumerSubCmd.Parse(os.Args[2:])
+		err = loadConfig(consumerSubCmd, os.Args[2:])
 	case "http":
-		httpSubCmd.Parse(os.Args[2:])
+		err = loadConfig(httpSubCmd, os.Args[2:])
+	case "config":
+		if len(os.Args) < 3 {
+			fmt.Println("validate or dump config action is required !")
+			os.Exit(1)
+		}
+		err = loadConfig(configSubCmd, os.Args[3:])
 	default:
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
+	if err != nil {
+		fmt.Printf("Invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	initLogging()
+
+	// Spans still queued are exported whether a server gives up or the process is stopped
+	defer shutdownTracing()
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		log.Info("Shutting down")
+		shutdownTracing()
+		os.Exit(0)
+	}()
+
 	if consumerSubCmd.Parsed() {
 		StartConsumer()
 	}
 	if httpSubCmd.Parsed() {
 		StartHttpServer()
 	}
+	if configSubCmd.Parsed() {
+		runConfigCommand(os.Args[2])
+	}
 }
 
-func initRedis(options *redis.Options) {
-	log.Infof("Initiating redis...")
-	redisCli = redis.NewClient(options)
+func addCommonFlags(fs *flag.FlagSet) {
+	fs.StringVar(&configFile, "config", "", "Config file (.yaml, .toml or .json), also read from INQUIRY_CONFIG")
+	fs.StringVar(&cfg.Kafka.Broker, "broker", cfg.Kafka.Broker, "Kafka broker address")
+	fs.StringVar(&cfg.Kafka.Topic, "topic", cfg.Kafka.Topic, "Name of the topic")
+	fs.StringVar(&cfg.Redis.Mode, "redisMode", cfg.Redis.Mode, "Redis deployment: single, cluster or sentinel")
+	fs.StringVar(&cfg.Redis.Address, "redisAddr", cfg.Redis.Address, "Redis address, comma separated seed nodes or sentinels in cluster and sentinel mode")
+	fs.StringVar(&cfg.Redis.MasterName, "redisMaster", cfg.Redis.MasterName, "Name of the primary monitored by the sentinels")
+	fs.IntVar(&cfg.Redis.DB, "redisDB", cfg.Redis.DB, "Redis database number")
+	fs.StringVar(&cfg.Redis.Channel, "redisChan", cfg.Redis.Channel, "Redis channel to listen")
+	fs.StringVar(&cfg.Tracing.Exporter, "traceExporter", cfg.Tracing.Exporter, "Span exporter: none, stdout, file or otlp")
+	fs.StringVar(&cfg.Tracing.File, "traceFile", cfg.Tracing.File, "File receiving spans with the file exporter")
+	fs.StringVar(&cfg.Tracing.Endpoint, "traceEndpoint", cfg.Tracing.Endpoint, "OTLP/HTTP traces URL with the otlp exporter")
+	fs.StringVar(&cfg.Log.Format, "logFormat", cfg.Log.Format, "Log format: text or json")
+	fs.StringVar(&cfg.Log.Level, "logLevel", cfg.Log.Level, "Log level: debug, info, warn or error")
+	fs.IntVar(&cfg.Log.SampleRate, "logSample", cfg.Log.SampleRate, "Write per-message info lines for one inquiry in this many, 1 for all")
+	fs.Float64Var(&cfg.Tracing.SampleRatio, "traceSampleRatio", cfg.Tracing.SampleRatio, "Share of new traces recorded, between 0 and 1")
+	fs.BoolVar(&cfg.Faults.Enabled, "faults", cfg.Faults.Enabled, "Whether to inject the faults of the config into kafka and redis traffic and serve /faults to change them")
+	fs.BoolVar(&cfg.Auth.Enabled, "auth", cfg.Auth.Enabled, "Whether to authenticate and authorize inquiries and /faults, see the auth section of the config")
+	fs.StringVar(&cfg.Auth.JWKSFile, "jwks", cfg.Auth.JWKSFile, "JWKS file of the keys JWTs are verified against, enables JWT authentication when set")
+}
+
+func addConsumerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.Kafka.ConsumerGroup, "cg", cfg.Kafka.ConsumerGroup, "Name of the Kafka consumer group")
+	fs.StringVar(&cfg.Consumer.AdminAddress, "adminAddr", cfg.Consumer.AdminAddress, "Admin listen address serving /metrics, /healthz and /readyz, empty to disable")
+	fs.StringVar(&cfg.Consumer.Delay, "delay", cfg.Consumer.Delay, "Synthetic delay distribution: fixed, uniform, normal, exponential, lognormal or empirical")
+	fs.DurationVar(&cfg.Consumer.DelayMin, "minD", cfg.Consumer.DelayMin, "Minimum synthetic delay duration")
+	fs.DurationVar(&cfg.Consumer.DelayMax, "maxD", cfg.Consumer.DelayMax, "Maximum synthetic delay duration, 0 for no maximum except with the uniform distribution")
+	fs.DurationVar(&cfg.Consumer.DelayMean, "delayMean", cfg.Consumer.DelayMean, "Mean synthetic delay of the fixed, normal, exponential and lognormal distributions")
+	fs.DurationVar(&cfg.Consumer.DelayStdDev, "delayStdDev", cfg.Consumer.DelayStdDev, "Standard deviation of the synthetic delay with the normal and lognormal distributions")
+	fs.StringVar(&cfg.Consumer.DelayHistogram, "delayHistogram", cfg.Consumer.DelayHistogram, "File of the empirical distribution, one \"<upper bound> <weight>\" bucket per line")
+	fs.IntVar(&cfg.Consumer.Seed, "seed", cfg.Consumer.Seed, "Seed of the synthetic delays for reproducible runs, 0 to seed from the clock")
+	fs.BoolVar(&cfg.Consumer.Async, "async", cfg.Consumer.Async, "Whether to process each message from kafka asynchronously or not")
+	fs.IntVar(&cfg.Consumer.Parts, "parts", cfg.Consumer.Parts, "Number of partial responses published before the final one on streaming inquiries")
+}
+
+func addHttpFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.GRPC.Address, "grpcAddr", cfg.GRPC.Address, "gRPC listen address, empty to disable")
+	fs.StringVar(&cfg.HTTP.Address, "addr", cfg.HTTP.Address, "HTTP listen address")
+	fs.StringVar(&cfg.HTTP.TLSCert, "tlsCert", cfg.HTTP.TLSCert, "TLS certificate file, serves HTTPS when set")
+	fs.StringVar(&cfg.HTTP.TLSKey, "tlsKey", cfg.HTTP.TLSKey, "TLS private key file")
+	fs.StringVar(&cfg.HTTP.TLSClientCA, "tlsClientCA", cfg.HTTP.TLSClientCA, "CA bundle to verify client certificates against, enables mTLS when set")
+	fs.DurationVar(&cfg.HTTP.ReadHeaderTimeout, "readHeaderTimeout", cfg.HTTP.ReadHeaderTimeout, "Maximum duration for reading request headers")
+	fs.DurationVar(&cfg.HTTP.WriteTimeout, "writeTimeout", cfg.HTTP.WriteTimeout, "Maximum duration before timing out writes of the response")
+	fs.DurationVar(&cfg.HTTP.IdleTimeout, "idleTimeout", cfg.HTTP.IdleTimeout, "Maximum duration to wait for the next request on keep-alive connections")
+	fs.IntVar(&cfg.HTTP.MaxHeaderBytes, "maxHeaderBytes", cfg.HTTP.MaxHeaderBytes, "Maximum size of request headers in bytes")
+	fs.BoolVar(&cfg.HTTP.H2C, "h2c", cfg.HTTP.H2C, "Whether to serve HTTP/2 over cleartext connections")
+	fs.DurationVar(&cfg.Inquiry.Timeout, "timeout", cfg.Inquiry.Timeout, "How long to wait for the final response")
+	fs.DurationVar(&cfg.Kafka.ProduceTimeout, "produceTimeout", cfg.Kafka.ProduceTimeout, "How long to wait for kafka to acknowledge an inquiry")
+	fs.StringVar(&cfg.Admission.Mode, "admission", cfg.Admission.Mode, "Admission control of outstanding inquiries: off, static, aimd or gradient")
+	fs.IntVar(&cfg.Admission.Limit, "admissionLimit", cfg.Admission.Limit, "Fixed limit of outstanding inquiries, or the initial one in the adaptive modes")
+	fs.BoolVar(&cfg.RateLimit.Enabled, "rateLimit", cfg.RateLimit.Enabled, "Whether to rate limit every client per route")
+	fs.StringVar(&cfg.RateLimit.Backend, "rateLimitBackend", cfg.RateLimit.Backend, "Where the rate limit buckets are kept: local or redis to share them between instances")
+	fs.DurationVar(&cfg.Cache.Freshness, "cacheFresh", cfg.Cache.Freshness, "Serve the cached final response of an ID while younger than this, 0 to always ask the consumer")
+	fs.BoolVar(&cfg.Cache.Coalesce, "coalesce", cfg.Cache.Coalesce, "Whether concurrent inquiries for the same ID share one kafka round trip")
+	fs.DurationVar(&cfg.Cache.StaleFor, "cacheStale", cfg.Cache.StaleFor, "Keep responses this long past their freshness to serve when the consumer is slow or failing, 0 to disable")
+	fs.DurationVar(&cfg.Cache.StaleTimeout, "staleTimeout", cfg.Cache.StaleTimeout, "How long to wait for the fresh response before serving the stale one")
+	fs.BoolVar(&cfg.Breaker.Enabled, "breaker", cfg.Breaker.Enabled, "Whether to fail fast with 503 while kafka or redis is failing")
+}
 
-	err := redisCli.Ping().Err()
+func initRedis(pool RedisPoolConfig) {
+	log.WithField("mode", cfg.Redis.Mode).Info("Initiating redis...")
+	cli, err := newRedisClient(pool)
 	if err != nil {
 		panic(err)
 	}
+	redisCli = withPublishFaults(goRedis{cli})
+
+	// An unreachable redis shows on /readyz rather than preventing startup
+	if err := redisCli.Ping().Err(); err != nil {
+		log.WithError(err).Warn("Redis isn't reachable yet")
+	}
+}
+
+// kafkaConfig layers kafka.properties and the role's own properties on top of the given ones.
+func kafkaConfig(role string, roleProps map[string]string, base kafka.ConfigMap) *kafka.ConfigMap {
+	return config.KafkaConfigMap(role, cfg.Kafka.Properties, roleProps, base)
 }
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	MetricsNamespace = "inquiry"
+
+	// Outcomes of an inquiry as labelled on inquiryDuration
+	OutcomeOK      = "ok"
+	OutcomeTimeout = "timeout"
+	OutcomeError   = "error"
+	OutcomeGone    = "client_gone"
+
+	// ConsumerLagInterval is how often the consumer lag per partition is refreshed
+	ConsumerLagInterval = 5 * time.Second
+)
+
+var (
+	inquiryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: MetricsNamespace,
+		Name:      "duration_seconds",
+		Help:      "End-to-end inquiry latency, from receiving the call to sending the final response.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 20},
+	}, []string{"endpoint", "outcome"})
+
+	kafkaDeliveryDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: MetricsNamespace,
+		Name:      "kafka_delivery_duration_seconds",
+		Help:      "Time taken for kafka to acknowledge a published inquiry.",
+		Buckets:   prometheus.ExponentialBuckets(.001, 2, 14),
+	})
+
+	redisWaitDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: MetricsNamespace,
+		Name:      "redis_wait_duration_seconds",
+		Help:      "Time between kafka acknowledging an inquiry and its final response arriving from redis.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 20},
+	})
+
+	timeoutsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "timeouts_total",
+		Help:      "Inquiries which gave up waiting for a response.",
+	}, []string{"endpoint"})
+
+	errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "errors_total",
+		Help:      "Errors by the stage they happened in.",
+	}, []string{"stage"})
+
+	staleSkippedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "stale_messages_skipped_total",
+		Help:      "Messages skipped because they were older than inquiry.staleAfter.",
+	}, []string{"component"})
+
+	responsesDroppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "responses_dropped_total",
+		Help:      "Responses dropped because the waiter wasn't keeping up.",
+	})
+
+	waitersGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "waiters",
+		Help:      "Inquiries waiting for a response, i.e. the size of the wait map.",
+	}, func() float64 {
+		inqMapMutex.RLock()
+		defer inqMapMutex.RUnlock()
+		return float64(len(inqWaitMap))
+	})
+
+	redisSubscriptionUp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "redis_subscription_up",
+		Help:      "Whether the http server is subscribed to the response channel.",
+	})
+
+	redisSubscriptionLostTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "redis_subscription_lost_total",
+		Help:      "Times the subscription to the response channel was lost.",
+	})
+
+	redisResubscribesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "redis_resubscribe_attempts_total",
+		Help:      "Attempts to subscribe again to the response channel by outcome.",
+	}, []string{"outcome"})
+
+	circuitBreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "circuit_breaker_state",
+		Help:      "State of the circuit breaker: 0 closed, 1 half-open, 2 open.",
+	}, []string{"breaker"})
+
+	circuitBreakerTransitionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "circuit_breaker_transitions_total",
+		Help:      "Circuit breaker state changes by the state entered.",
+	}, []string{"breaker", "state"})
+
+	circuitBreakerRejectedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "circuit_breaker_rejected_total",
+		Help:      "Inquiries failed fast by an open circuit breaker.",
+	}, []string{"breaker"})
+
+	admissionLimit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "admission_limit",
+		Help:      "Outstanding inquiries admitted at most, adjusted to the latency in the adaptive modes.",
+	})
+
+	admissionInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "admission_in_flight",
+		Help:      "Inquiries holding an admission slot.",
+	})
+
+	admissionRejectedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "admission_rejected_total",
+		Help:      "Inquiries rejected with 429 because the admission limit was reached.",
+	})
+
+	rateLimitedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected with 429 because the client ran out of tokens, by route.",
+	}, []string{"route"})
+
+	authFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "auth_failures_total",
+		Help:      "Requests rejected as unauthenticated or forbidden.",
+	}, []string{"reason"})
+
+	cacheLookupsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "cache_lookups_total",
+		Help:      "Cache lookups before publishing an inquiry, by result.",
+	}, []string{"result"})
+
+	coalescedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "coalesced_total",
+		Help:      "Inquiries answered by a kafka round trip shared with concurrent ones for the same ID.",
+	})
+
+	staleServedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "stale_served_total",
+		Help:      "Stale responses served because the fresh one was slow or failed, by reason.",
+	}, []string{"reason"})
+
+	consumerInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "consumer_in_flight_messages",
+		Help:      "Messages being processed by the consumer, one goroutine each in async mode.",
+	})
+
+	consumerProcessedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "consumer_processed_total",
+		Help:      "Messages processed by the consumer by outcome.",
+	}, []string{"outcome"})
+
+	consumerLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "consumer_lag_messages",
+		Help:      "Messages in the partition not yet read by this consumer.",
+	}, []string{"topic", "partition"})
+
+	faultsInjectedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "faults_injected_total",
+		Help:      "Faults injected by the point they were injected at and their kind.",
+	}, []string{"point", "fault"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		inquiryDuration,
+		kafkaDeliveryDuration,
+		redisWaitDuration,
+		timeoutsTotal,
+		errorsTotal,
+		staleSkippedTotal,
+		responsesDroppedTotal,
+		waitersGauge,
+		redisSubscriptionUp,
+		redisSubscriptionLostTotal,
+		redisResubscribesTotal,
+		circuitBreakerState,
+		circuitBreakerTransitionsTotal,
+		circuitBreakerRejectedTotal,
+		admissionLimit,
+		admissionInFlight,
+		admissionRejectedTotal,
+		rateLimitedTotal,
+		authFailuresTotal,
+		cacheLookupsTotal,
+		coalescedTotal,
+		staleServedTotal,
+		consumerInFlight,
+		consumerProcessedTotal,
+		consumerLag,
+		faultsInjectedTotal,
+	)
+}
+
+func metricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+func observeInquiry(endpoint, outcome string, start time.Time) {
+	inquiryDuration.WithLabelValues(endpoint, outcome).Observe(time.Since(start).Seconds())
+	if outcome == OutcomeTimeout {
+		timeoutsTotal.WithLabelValues(endpoint).Inc()
+	}
+}
+
+// trackConsumerLag periodically compares the high watermark of every assigned partition
+// with the next offset to be read.
+func trackConsumerLag(c kafkaConsumer, positions *partitionPositions, done <-chan struct{}) {
+	ticker := time.NewTicker(ConsumerLagInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		assignment, err := c.Assignment()
+		if err != nil {
+			log.WithError(err).Warn("Can't get consumer assignment")
+			continue
+		}
+
+		consumerLag.Reset()
+		for _, tp := range assignment {
+			_, high, err := c.QueryWatermarkOffsets(*tp.Topic, tp.Partition, 1000)
+			if err != nil {
+				log.WithField("partition", tp.Partition).WithError(err).Warn("Can't query watermark offsets")
+				continue
+			}
+
+			next, ok := positions.get(tp.Partition)
+			if !ok {
+				continue
+			}
+			lag := high - next
+			if lag < 0 {
+				lag = 0
+			}
+			consumerLag.WithLabelValues(*tp.Topic, strconv.Itoa(int(tp.Partition))).Set(float64(lag))
+		}
+	}
+}
+
+// partitionPositions remembers the next offset to be read for every partition.
+type partitionPositions struct {
+	mu   sync.Mutex
+	next map[int32]int64
+}
+
+func newPartitionPositions() *partitionPositions {
+	return &partitionPositions{next: map[int32]int64{}}
+}
+
+func (p *partitionPositions) set(tp kafka.TopicPartition) {
+	p.mu.Lock()
+	p.next[tp.Partition] = int64(tp.Offset) + 1
+	p.mu.Unlock()
+}
+
+func (p *partitionPositions) get(partition int32) (int64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	next, ok := p.next[partition]
+	return next, ok
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	// ProblemContentType is the media type of error bodies, RFC 7807
+	ProblemContentType = "application/problem+json"
+	// ProblemTypePrefix makes the type URI of a problem, e.g. urn:inquiry:problem:timeout
+	ProblemTypePrefix = "urn:inquiry:problem:"
+
+	// InquiryIDMaxLength bounds inquiry IDs, they end up in kafka messages and redis keys
+	InquiryIDMaxLength = 128
+)
+
+// Problem types, the last segment of the type URI
+const (
+	ProblemBadInput        = "bad-input"
+	ProblemUnauthenticated = "unauthenticated"
+	ProblemForbidden       = "forbidden"
+	ProblemRateLimited     = "rate-limited"
+	ProblemOverloaded      = "overloaded"
+	ProblemUnavailable     = "unavailable"
+	ProblemTimeout         = "timeout"
+	ProblemInternal        = "internal"
+)
+
+// validID keeps IDs to characters which are safe in URLs, log lines and redis hash tags
+var validID = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+// problem is an RFC 7807 problem details object, extended with the correlation id of the request.
+type problem struct {
+	Type          string `json:"type"`
+	Title         string `json:"title"`
+	Status        int    `json:"status"`
+	Detail        string `json:"detail,omitempty"`
+	Instance      string `json:"instance,omitempty"`
+	CorrelationID string `json:"correlationId,omitempty"`
+
+	retryAfter time.Duration
+}
+
+func newProblem(kind string, status int, detail string) *problem {
+	return &problem{Type: ProblemTypePrefix + kind, Title: http.StatusText(status), Status: status, Detail: detail}
+}
+
+// errBadInput rejects a malformed inquiry before anything is published.
+type errBadInput struct {
+	reason string
+}
+
+func (e *errBadInput) Error() string {
+	return e.reason
+}
+
+// errBrokerUnavailable is kafka failing to take the inquiry.
+type errBrokerUnavailable struct {
+	err error
+}
+
+func (e *errBrokerUnavailable) Error() string {
+	return "can't publish to kafka: " + e.err.Error()
+}
+
+func validateID(id string) error {
+	switch {
+	case id == "":
+		return &errBadInput{"id is required"}
+	case len(id) > InquiryIDMaxLength:
+		return &errBadInput{fmt.Sprintf("id is longer than %d characters", InquiryIDMaxLength)}
+	case !validID.MatchString(id):
+		return &errBadInput{"id may only contain letters, digits, '.', '_', ':' and '-'"}
+	}
+	return nil
+}
+
+// problemFor maps an error to the problem answered for it. Unexpected errors are answered without their details.
+func problemFor(err error) *problem {
+	switch err := err.(type) {
+	case *errBadInput:
+		return newProblem(ProblemBadInput, http.StatusBadRequest, err.Error())
+	case *errOverloaded:
+		p := newProblem(ProblemOverloaded, http.StatusTooManyRequests, "Too many outstanding inquiries, retry later")
+		p.retryAfter = AdmissionRetryAfter
+		return p
+	case *errBreakerOpen:
+		p := newProblem(ProblemUnavailable, http.StatusServiceUnavailable, "Service temporarily unavailable, retry later")
+		p.retryAfter = err.retryAfter
+		return p
+	case *errBrokerUnavailable:
+		return newProblem(ProblemUnavailable, http.StatusServiceUnavailable, "Can't publish the inquiry to kafka")
+	}
+	if err == errInquiryTimeout {
+		return newProblem(ProblemTimeout, http.StatusGatewayTimeout, "No response from the backend in time")
+	}
+	return newProblem(ProblemInternal, http.StatusInternalServerError, "")
+}
+
+// forRequest points the problem at the request it occurred on.
+func (p *problem) forRequest(r *http.Request) *problem {
+	p.Instance = r.URL.Path
+	p.CorrelationID = correlationID(r.Context())
+	return p
+}
+
+// writeProblem answers with the problem as problem+json, carrying the correlation id and a Retry-After in
+// whole seconds when the client should come back.
+func writeProblem(w http.ResponseWriter, r *http.Request, p *problem) {
+	p.forRequest(r)
+	if p.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(p.retryAfter.Seconds()))))
+	}
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	w.Write(b)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	writeProblem(w, r, problemFor(err))
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpccreds "google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	RateLimitLocal = "local"
+	RateLimitRedis = "redis"
+
+	// RateLimitKeyPrefix namespaces the buckets of the redis limiter
+	RateLimitKeyPrefix = "ratelimit:"
+	// RateLimitSweepInterval is how often the local limiter forgets buckets which refilled
+	RateLimitSweepInterval = time.Minute
+)
+
+// Client kinds prefixing the client id, so an API key can't pose as a certificate subject
+const (
+	ClientPrincipal = "principal"
+	ClientAPIKey    = "key"
+	ClientCert      = "cert"
+	ClientIP        = "ip"
+)
+
+var rateLimiter bucketLimiter
+
+// rate is a token bucket refilling Rate tokens per second up to Burst.
+type rate struct {
+	Rate  float64
+	Burst int
+}
+
+// bucketLimiter takes a token from the bucket of key, reporting the tokens left afterwards.
+type bucketLimiter interface {
+	take(key string, r rate) (allowed bool, tokens float64, err error)
+}
+
+func initRateLimit() {
+	if !cfg.RateLimit.Enabled {
+		return
+	}
+
+	local := newLocalLimiter()
+	rateLimiter = local
+	if cfg.RateLimit.Backend == RateLimitRedis {
+		rateLimiter = &redisLimiter{cli: redisCli, fallback: local}
+	}
+	log.WithField("backend", cfg.RateLimit.Backend).WithField("rate", cfg.RateLimit.Rate).WithField("burst", cfg.RateLimit.Burst).Info("Rate limiting clients")
+}
+
+// parseRate reads a rate given as <tokens per second>/<burst>, e.g. 10/20.
+func parseRate(s string) (rate, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return rate{}, fmt.Errorf("expected <rate>/<burst>, got %q", s)
+	}
+	r, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || r <= 0 {
+		return rate{}, fmt.Errorf("rate of %q must be a positive number", s)
+	}
+	burst, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil || burst <= 0 {
+		return rate{}, fmt.Errorf("burst of %q must be a positive integer", s)
+	}
+	return rate{Rate: r, Burst: burst}, nil
+}
+
+// routeRate is the limit of a route, ratelimit.routes overriding the default one. Validate made sure it parses.
+func routeRate(route string) rate {
+	if s, ok := cfg.RateLimit.Routes[route]; ok {
+		r, _ := parseRate(s)
+		return r
+	}
+	return rate{Rate: cfg.RateLimit.Rate, Burst: cfg.RateLimit.Burst}
+}
+
+// clientID identifies the caller by its authenticated principal, else by API key, else by the subject of its
+// client certificate, else by address. API keys are hashed, they'd otherwise end up in redis and the logs.
+func clientID(r *http.Request) string {
+	if p := principalFrom(r.Context()); p != nil {
+		return ClientPrincipal + ":" + p.Name
+	}
+	if key := r.Header.Get(cfg.RateLimit.ClientHeader); key != "" {
+		return apiKeyClient(key)
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return ClientCert + ":" + r.TLS.PeerCertificates[0].Subject.String()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return ClientIP + ":" + host
+}
+
+func apiKeyClient(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return ClientAPIKey + ":" + hex.EncodeToString(sum[:8])
+}
+
+// takeToken takes a token from the bucket of the client on the route, returning the route's limit and the
+// tokens left for the RateLimit headers.
+func takeToken(ctx context.Context, client, route string) (allowed bool, lim rate, tokens float64) {
+	lim = routeRate(route)
+	allowed, tokens, err := rateLimiter.take(client+"|"+route, lim)
+	if err != nil {
+		errorsTotal.WithLabelValues("ratelimit").Inc()
+		logger(ctx).WithError(err).Warn("Rate limiter failed, using the local one")
+	}
+	if !allowed {
+		rateLimitedTotal.WithLabelValues(route).Inc()
+		logger(ctx).WithField("client", client).WithField("route", route).Debug("Rate limited")
+	}
+	return allowed, lim, tokens
+}
+
+// rateLimit gives every client one token bucket per route, rejecting requests with 429 once it's empty.
+// Responses carry the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers.
+func rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.RateLimit.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		route := "unknown"
+		if cr := mux.CurrentRoute(r); cr != nil && cr.GetName() != "" {
+			route = cr.GetName()
+		}
+		allowed, lim, tokens := takeToken(r.Context(), clientID(r), route)
+
+		// Reset is when the bucket would be full again
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(lim.Burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(int(math.Max(0, math.Floor(tokens)))))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil((float64(lim.Burst)-tokens)/lim.Rate))))
+
+		if !allowed {
+			p := newProblem(ProblemRateLimited, http.StatusTooManyRequests, "Rate limit exceeded, retry later")
+			p.retryAfter = time.Duration((1 - tokens) / lim.Rate * float64(time.Second))
+			writeProblem(w, r, p)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rpcRoutes names the gRPC methods after the http routes they mirror, so they share ratelimit.routes
+var rpcRoutes = map[string]string{
+	"/inquiry.InquiryService/Inquire":       "inquiry",
+	"/inquiry.InquiryService/InquireStream": "stream",
+}
+
+// rpcRoute names a gRPC method after the http route it mirrors.
+func rpcRoute(fullMethod string) string {
+	if route, ok := rpcRoutes[fullMethod]; ok {
+		return route
+	}
+	return "unknown"
+}
+
+// rpcClientID is clientID for gRPC. Calls are authenticated by authUnaryRPC and authStreamRPC before they get
+// here, so clients are told apart by principal, else by certificate, else by address.
+func rpcClientID(ctx context.Context) string {
+	if p := principalFrom(ctx); p != nil {
+		return ClientPrincipal + ":" + p.Name
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ClientIP + ":unknown"
+	}
+	if tlsInfo, ok := p.AuthInfo.(grpccreds.TLSInfo); ok && len(tlsInfo.State.PeerCertificates) > 0 {
+		return ClientCert + ":" + tlsInfo.State.PeerCertificates[0].Subject.String()
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+	return ClientIP + ":" + host
+}
+
+// rateLimitRPC is rateLimit for gRPC, failing calls with ResourceExhausted once the bucket is empty. The limit
+// goes in the ratelimit-* headers.
+func rateLimitRPC(ctx context.Context, fullMethod string) (metadata.MD, error) {
+	if !cfg.RateLimit.Enabled {
+		return nil, nil
+	}
+
+	allowed, lim, tokens := takeToken(ctx, rpcClientID(ctx), rpcRoute(fullMethod))
+	md := metadata.Pairs(
+		"ratelimit-limit", strconv.Itoa(lim.Burst),
+		"ratelimit-remaining", strconv.Itoa(int(math.Max(0, math.Floor(tokens)))),
+		"ratelimit-reset", strconv.Itoa(int(math.Ceil((float64(lim.Burst)-tokens)/lim.Rate))),
+	)
+	if !allowed {
+		md.Set("retry-after", strconv.Itoa(int(math.Ceil((1-tokens)/lim.Rate))))
+		return md, status.Error(codes.ResourceExhausted, "rate limit exceeded, retry later")
+	}
+	return md, nil
+}
+
+func rateLimitUnaryRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, err := rateLimitRPC(ctx, info.FullMethod)
+	if md != nil {
+		grpc.SetHeader(ctx, md)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func rateLimitStreamRPC(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	md, err := rateLimitRPC(ss.Context(), info.FullMethod)
+	if md != nil {
+		ss.SetHeader(md)
+	}
+	if err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// localLimiter keeps the buckets in memory, limiting each gateway instance on its own.
+type localLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time
+}
+
+func newLocalLimiter() *localLimiter {
+	l := &localLimiter{buckets: map[string]*bucket{}}
+	go l.sweep()
+	return l
+}
+
+func (l *localLimiter) take(key string, r rate) (bool, float64, error) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(r.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(r.Burst), b.tokens+now.Sub(b.last).Seconds()*r.Rate)
+	b.last = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	b.full = now.Add(time.Duration((float64(r.Burst) - b.tokens) / r.Rate * float64(time.Second)))
+	return allowed, b.tokens, nil
+}
+
+// sweep drops the buckets which refilled, a new one starts full anyway.
+func (l *localLimiter) sweep() {
+	for range time.Tick(RateLimitSweepInterval) {
+		now := time.Now()
+		l.mu.Lock()
+		for key, b := range l.buckets {
+			if now.After(b.full) {
+				delete(l.buckets, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// takeScript refills and takes from the bucket atomically. The caller passes the time since scripts can't
+// read the clock on older redis, gateways are assumed roughly in sync.
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(state[1]) or burst
+local last = tonumber(state[2]) or now
+
+tokens = math.min(burst, tokens + math.max(0, now - last) / 1000 * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "last", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// redisLimiter shares the buckets between gateway instances, falling back to the local limiter when redis fails.
+type redisLimiter struct {
+	cli      redisClient
+	fallback *localLimiter
+}
+
+func (l *redisLimiter) take(key string, r rate) (bool, float64, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	res, err := takeScript.Run(l.cli, []string{RateLimitKeyPrefix + key}, r.Rate, r.Burst, now).Result()
+	if err != nil {
+		allowed, tokens, _ := l.fallback.take(key, r)
+		return allowed, tokens, err
+	}
+
+	vals, ok := res.([]interface{})
+	if !ok || len(vals) != 2 {
+		allowed, tokens, _ := l.fallback.take(key, r)
+		return allowed, tokens, fmt.Errorf("unexpected rate limit script result %v", res)
+	}
+	allowed, _ := vals[0].(int64)
+	s, _ := vals[1].(string)
+	tokens, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return allowed == 1, 0, err
+	}
+	return allowed == 1, tokens, nil
+}
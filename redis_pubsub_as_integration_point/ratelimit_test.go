@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestRateLimitUnaryRPC(t *testing.T) {
+	cfg = defaultConfig()
+	cfg.RateLimit.Enabled = true
+	cfg.RateLimit.Rate = 0.001
+	cfg.RateLimit.Burst = 2
+	rateLimiter = newLocalLimiter()
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/inquiry.InquiryService/Inquire"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	call := func(addr, key string, p *principal) error {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(addr), Port: 50000}})
+		if key != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(cfg.RateLimit.ClientHeader, key))
+		}
+		if p != nil {
+			ctx = withPrincipal(ctx, p)
+		}
+		_, err := rateLimitUnaryRPC(ctx, nil, info, handler)
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := call("10.0.0.1", "", nil); err != nil {
+			t.Fatalf("call %d within the burst: %v", i, err)
+		}
+	}
+	if err := call("10.0.0.1", "", nil); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("call past the burst: %v, want ResourceExhausted", err)
+	}
+
+	// Other clients have buckets of their own
+	if err := call("10.0.0.2", "", nil); err != nil {
+		t.Errorf("another address: %v", err)
+	}
+	if err := call("10.0.0.1", "", &principal{Name: "billing"}); err != nil {
+		t.Errorf("a principal from the same address: %v", err)
+	}
+
+	// An unchecked API key doesn't get a bucket of its own
+	if err := call("10.0.0.1", "rotated", nil); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("an unchecked API key from the same address: %v, want ResourceExhausted", err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	RedisModeSingle   = "single"
+	RedisModeCluster  = "cluster"
+	RedisModeSentinel = "sentinel"
+)
+
+// newRedisClient connects to a single node, a cluster or the primary behind sentinels depending on redis.mode.
+// redis.address is comma separated: the node, the cluster seed nodes or the sentinels respectively.
+func newRedisClient(pool RedisPoolConfig) (redis.UniversalClient, error) {
+	addrs := strings.Split(cfg.Redis.Address, ",")
+	for i := range addrs {
+		addrs[i] = strings.TrimSpace(addrs[i])
+	}
+
+	switch cfg.Redis.Mode {
+	case RedisModeSingle:
+		return redis.NewClient(&redis.Options{
+			Addr:         addrs[0],
+			Password:     cfg.Redis.Password,
+			DB:           cfg.Redis.DB,
+			PoolSize:     pool.PoolSize,
+			MinIdleConns: pool.MinIdleConns,
+			PoolTimeout:  pool.PoolTimeout,
+		}), nil
+	case RedisModeCluster:
+		// Pool settings apply to each node of the cluster
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        addrs,
+			Password:     cfg.Redis.Password,
+			PoolSize:     pool.PoolSize,
+			MinIdleConns: pool.MinIdleConns,
+			PoolTimeout:  pool.PoolTimeout,
+		}), nil
+	case RedisModeSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			SentinelAddrs: addrs,
+			MasterName:    cfg.Redis.MasterName,
+			Password:      cfg.Redis.Password,
+			DB:            cfg.Redis.DB,
+			PoolSize:      pool.PoolSize,
+			MinIdleConns:  pool.MinIdleConns,
+			PoolTimeout:   pool.PoolTimeout,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown redis mode %q, use single, cluster or sentinel", cfg.Redis.Mode)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// listenAndServe runs the HTTP server using the listener flags, serving TLS when a certificate is given
+// and cleartext HTTP/2 (h2c) when asked to.
+func listenAndServe(handler http.Handler) error {
+	srv := &http.Server{
+		Addr:              cfg.HTTP.Address,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+		MaxHeaderBytes:    cfg.HTTP.MaxHeaderBytes,
+	}
+
+	if cfg.HTTP.TLSCert != "" || cfg.HTTP.TLSKey != "" {
+		tlsConfig, err := buildTLSConfig()
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = tlsConfig
+
+		// HTTP/2 is negotiated through ALPN automatically
+		log.WithField("addr", cfg.HTTP.Address).WithField("mTLS", cfg.HTTP.TLSClientCA != "").Info("HTTPS server is listening...")
+		return srv.ListenAndServeTLS("", "")
+	}
+
+	if cfg.HTTP.H2C {
+		srv.Handler = h2c.NewHandler(handler, &http2.Server{IdleTimeout: cfg.HTTP.IdleTimeout})
+	}
+
+	log.WithField("addr", cfg.HTTP.Address).WithField("h2c", cfg.HTTP.H2C).Info("HTTP server is listening...")
+	return srv.ListenAndServe()
+}
+
+// buildTLSConfig is the TLS both the http and gRPC servers are served over.
+func buildTLSConfig() (*tls.Config, error) {
+	if cfg.HTTP.TLSCert == "" || cfg.HTTP.TLSKey == "" {
+		return nil, fmt.Errorf("both http.tlsCert and http.tlsKey are required to serve TLS")
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.HTTP.TLSCert, cfg.HTTP.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.HTTP.TLSClientCA != "" {
+		caBytes, err := ioutil.ReadFile(cfg.HTTP.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("no certificate found in %s", cfg.HTTP.TLSClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
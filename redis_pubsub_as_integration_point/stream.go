@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// StreamBufferSize is how many responses may queue up for a single streaming client
+	StreamBufferSize = 64
+)
+
+var (
+	upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+	}
+)
+
+// inquiryStream sends every partial response as a Server-Sent Event, finishing with the final one.
+func inquiryStream(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	msgId := vars["id"]
+	start := time.Now()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeProblem(w, r, newProblem(ProblemInternal, http.StatusInternalServerError, "Streaming unsupported"))
+		return
+	}
+
+	p, ok := startInquiry(w, r, msgId, true, StreamBufferSize)
+	if !ok {
+		observeInquiry("sse", OutcomeError, start)
+		return
+	}
+	defer p.release()
+
+	// A stream lasts as long as responses keep coming, StreamIdleTimeout bounds it rather than http.writeTimeout.
+	// WebSocket writes need no such thing, each one sets its own deadline on the hijacked connection.
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		logger(r.Context()).WithField("ID", msgId).WithError(err).Debug("Can't lift the write deadline of the stream")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	timer := time.NewTimer(cfg.Inquiry.StreamIdleTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case resp := <-p.Responses:
+			resBytes, err := json.Marshal(resp)
+			if err != nil {
+				panic(err)
+			}
+
+			event := "final"
+			if resp.Partial {
+				event = "partial"
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", resp.Seq, event, resBytes)
+			flusher.Flush()
+
+			if !resp.Partial {
+				p.answered()
+				msgLogger(r.Context()).WithField("ID", resp.ID).WithField("Seq", resp.Seq).Info("Stream completed")
+				observeInquiry("sse", OutcomeOK, start)
+				return
+			}
+			resetTimer(timer, cfg.Inquiry.StreamIdleTimeout)
+		case <-timer.C:
+			p.timedOut()
+			probBytes, err := json.Marshal(problemFor(errInquiryTimeout).forRequest(r))
+			if err != nil {
+				panic(err)
+			}
+			fmt.Fprintf(w, "event: timeout\ndata: %s\n\n", probBytes)
+			flusher.Flush()
+			observeInquiry("sse", OutcomeTimeout, start)
+			return
+		case <-r.Context().Done():
+			logger(r.Context()).WithField("ID", msgId).Info("Stream client went away")
+			observeInquiry("sse", OutcomeGone, start)
+			return
+		}
+	}
+}
+
+// inquiryWebSocket sends every partial response as a WebSocket text message, closing after the final one.
+func inquiryWebSocket(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	msgId := vars["id"]
+	start := time.Now()
+
+	// Bad input is answered before the upgrade, with a proper status
+	if err := validateID(msgId); err != nil {
+		writeError(w, r, err)
+		observeInquiry("ws", OutcomeError, start)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger(r.Context()).WithField("ID", msgId).WithError(err).Error("WebSocket upgrade failed")
+		return
+	}
+	defer conn.Close()
+
+	// The connection is already hijacked, a failed publish becomes a close frame
+	p, err := publishInquiry(r.Context(), msgId, true, StreamBufferSize)
+	if err != nil {
+		prob := problemFor(err)
+		code := websocket.CloseInternalServerErr
+		if prob.Status == http.StatusServiceUnavailable || prob.Status == http.StatusTooManyRequests {
+			code = websocket.CloseTryAgainLater
+		}
+		closeWebSocket(conn, code, prob.Detail)
+		observeInquiry("ws", OutcomeError, start)
+		return
+	}
+	defer p.release()
+
+	// Reading is required to process control frames and notice the client closing the connection
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	timer := time.NewTimer(cfg.Inquiry.StreamIdleTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case resp := <-p.Responses:
+			if err := conn.WriteJSON(resp); err != nil {
+				logger(r.Context()).WithField("ID", msgId).WithError(err).Warn("WebSocket write failed")
+				observeInquiry("ws", OutcomeGone, start)
+				return
+			}
+
+			if !resp.Partial {
+				p.answered()
+				msgLogger(r.Context()).WithField("ID", resp.ID).WithField("Seq", resp.Seq).Info("Stream completed")
+				closeWebSocket(conn, websocket.CloseNormalClosure, "")
+				observeInquiry("ws", OutcomeOK, start)
+				return
+			}
+			resetTimer(timer, cfg.Inquiry.StreamIdleTimeout)
+		case <-timer.C:
+			p.timedOut()
+			closeWebSocket(conn, websocket.CloseTryAgainLater, "Too long waiting")
+			observeInquiry("ws", OutcomeTimeout, start)
+			return
+		case <-gone:
+			logger(r.Context()).WithField("ID", msgId).Info("Stream client went away")
+			observeInquiry("ws", OutcomeGone, start)
+			return
+		}
+	}
+}
+
+func closeWebSocket(conn *websocket.Conn, code int, text string) {
+	msg := websocket.FormatCloseMessage(code, text)
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+}
+
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type sseEvent struct {
+	event string
+	resp  ResponseMessage
+}
+
+// readStream reads the Server-Sent Events of a stream until it ends.
+func readStream(url string) ([]sseEvent, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var events []sseEvent
+	var event string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			e := sseEvent{event: event}
+			if event != "timeout" {
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e.resp); err != nil {
+					return nil, err
+				}
+			}
+			events = append(events, e)
+		}
+	}
+	return events, scanner.Err()
+}
+
+func TestConcurrentStreamsForOneID(t *testing.T) {
+	f := startFlow(t, func(c *Config) {
+		c.Consumer.Parts = 2
+		c.Consumer.DelayMin = 150 * time.Millisecond
+		c.Consumer.DelayMax = 150 * time.Millisecond
+		c.Inquiry.StreamIdleTimeout = time.Second
+	})
+	defer f.close(t)
+
+	const streams = 3
+	results := make([][]sseEvent, streams)
+	errs := make([]error, streams)
+	var wg sync.WaitGroup
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = readStream(f.server.URL + "/inquiry/42/stream")
+		}(i)
+	}
+	wg.Wait()
+
+	// Each stream gets the partials and final response of its own inquiry, and nothing else
+	replyTo := map[string]bool{}
+	for i, events := range results {
+		if errs[i] != nil {
+			t.Fatalf("stream %d: %v", i, errs[i])
+		}
+		if len(events) != 3 || events[0].event != "partial" || events[1].event != "partial" || events[2].event != "final" {
+			t.Errorf("stream %d got %v, want 2 partials then the final response", i, events)
+			continue
+		}
+		for seq, e := range events {
+			if e.resp.ID != "42" || e.resp.Seq != seq || e.resp.ReplyTo != events[0].resp.ReplyTo {
+				t.Errorf("stream %d event %d: %+v", i, seq, e.resp)
+			}
+		}
+		replyTo[events[0].resp.ReplyTo] = true
+	}
+	if len(replyTo) != streams {
+		t.Errorf("%d distinct inquiries answered across %d streams", len(replyTo), streams)
+	}
+	if produced := f.produced(); produced != streams {
+		t.Errorf("%d messages produced, want %d", produced, streams)
+	}
+}
+
+func TestStreamOutlivesWriteTimeout(t *testing.T) {
+	f := startFlow(t, func(c *Config) {
+		c.Consumer.Parts = 2
+		c.Consumer.DelayMin = 150 * time.Millisecond
+		c.Consumer.DelayMax = 150 * time.Millisecond
+		c.HTTP.WriteTimeout = 50 * time.Millisecond
+		c.Inquiry.StreamIdleTimeout = time.Second
+	})
+	defer f.close(t)
+
+	// The responses come about 150ms in, well after the write timeout
+	events, err := readStream(f.server.URL + "/inquiry/42/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[2].event != "final" {
+		t.Errorf("got %v, want 2 partials then the final response", events)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// SubscriptionPingInterval is how long the subscription may stay silent before it's pinged
+	SubscriptionPingInterval = 5 * time.Second
+	// SubscribeBackoffMin and SubscribeBackoffMax bound the wait between attempts to subscribe again
+	SubscribeBackoffMin = 100 * time.Millisecond
+	SubscribeBackoffMax = 10 * time.Second
+)
+
+var (
+	pubSub   redisSubscription
+	pubSubMu sync.RWMutex
+	// subscribeAttempts counts failed attempts since the subscription was lost, reported by /readyz
+	subscribeAttempts int
+)
+
+// superviseSubscription keeps the response subscription alive until done is closed,
+// subscribing again with backoff whenever it's lost.
+func superviseSubscription(done <-chan struct{}) {
+	attempt := 0
+	for {
+		ps, err := subscribe()
+		if err != nil {
+			redisResubscribesTotal.WithLabelValues(OutcomeError).Inc()
+			attempt++
+			setSubscription(nil, attempt)
+
+			backoff := subscribeBackoff(attempt - 1)
+			log.WithError(err).WithField("attempt", attempt).WithField("backoff", backoff).Warn("Can't subscribe to redis")
+			select {
+			case <-done:
+				return
+			case <-time.After(backoff):
+			}
+			continue
+		}
+		if attempt > 0 {
+			redisResubscribesTotal.WithLabelValues(OutcomeOK).Inc()
+		}
+		attempt = 0
+
+		setSubscription(ps, 0)
+		log.WithField("Channel", cfg.Redis.Channel).Info("Start subscribing to redis")
+
+		stop := make(chan struct{})
+		go func() {
+			select {
+			case <-done:
+				ps.Close()
+			case <-stop:
+			}
+		}()
+		err = receiveResponses(ps)
+		close(stop)
+		setSubscription(nil, 0)
+		ps.Close()
+		if isDone(done) {
+			return
+		}
+		redisSubscriptionLostTotal.Inc()
+		log.WithError(err).WithField("Channel", cfg.Redis.Channel).Error("Redis subscription lost, subscribing again")
+	}
+}
+
+// subscribe waits for redis to confirm the subscription, so no response published afterwards is missed.
+func subscribe() (redisSubscription, error) {
+	ps := redisCli.SubscribeChannel(cfg.Redis.Channel)
+	if _, err := ps.ReceiveTimeout(SubscriptionPingInterval); err != nil {
+		ps.Close()
+		return nil, err
+	}
+	return ps, nil
+}
+
+// receiveResponses hands every response to its waiter until the subscription fails. A silent subscription
+// is pinged, and given up when not even the pong comes back.
+func receiveResponses(ps redisSubscription) error {
+	pinged := false
+	for {
+		msg, err := ps.ReceiveTimeout(SubscriptionPingInterval)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				if pinged {
+					return fmt.Errorf("no pong within %s", SubscriptionPingInterval)
+				}
+				if err := ps.Ping(); err != nil {
+					return err
+				}
+				pinged = true
+				continue
+			}
+			return err
+		}
+		pinged = false
+
+		switch msg := msg.(type) {
+		case *redis.Message:
+			handleResponse(msg.Payload)
+		case *redis.Subscription:
+			if msg.Kind == "unsubscribe" {
+				return fmt.Errorf("unsubscribed from %s", msg.Channel)
+			}
+		}
+	}
+}
+
+func setSubscription(ps redisSubscription, attempts int) {
+	pubSubMu.Lock()
+	pubSub = ps
+	subscribeAttempts = attempts
+	pubSubMu.Unlock()
+
+	if ps != nil {
+		redisSubscriptionUp.Set(1)
+	} else {
+		redisSubscriptionUp.Set(0)
+	}
+}
+
+func currentSubscription() (redisSubscription, int) {
+	pubSubMu.RLock()
+	defer pubSubMu.RUnlock()
+	return pubSub, subscribeAttempts
+}
+
+// subscribeBackoff doubles the wait on every attempt, with jitter so instances don't retry in lockstep.
+func subscribeBackoff(attempt int) time.Duration {
+	backoff := SubscribeBackoffMax
+	if attempt < 16 {
+		if d := SubscribeBackoffMin << uint(attempt); d < SubscribeBackoffMax {
+			backoff = d
+		}
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func isDone(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amura2406/inquiry-kafka-redis-poc/redis_pubsub_as_integration_point/tracing"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	TraceExporterNone   = "none"
+	TraceExporterStdout = "stdout"
+	TraceExporterFile   = "file"
+	TraceExporterOTLP   = "otlp"
+
+	// TraceExportTimeout bounds a single OTLP export request
+	TraceExportTimeout = 5 * time.Second
+)
+
+var tracingShutdown sync.Once
+
+// initTracing installs the global tracer, spans are only created for propagation with the none exporter.
+func initTracing(defaultService string) {
+	service := cfg.Tracing.ServiceName
+	if service == "" {
+		service = defaultService
+	}
+
+	var exporter tracing.Exporter
+	switch cfg.Tracing.Exporter {
+	case TraceExporterStdout:
+		exporter = tracing.NewWriterExporter(service, os.Stdout)
+	case TraceExporterFile:
+		fe, err := tracing.NewFileExporter(service, cfg.Tracing.File)
+		if err != nil {
+			panic(err)
+		}
+		exporter = fe
+	case TraceExporterOTLP:
+		exporter = tracing.NewOTLPExporter(service, cfg.Tracing.Endpoint, nil, TraceExportTimeout)
+	}
+
+	tracing.SetGlobal(tracing.NewTracer(exporter, cfg.Tracing.SampleRatio, func(err error, dropped int) {
+		errorsTotal.WithLabelValues("trace_export").Inc()
+		log.WithField("dropped", dropped).WithError(err).Warn("Can't export spans")
+	}))
+	log.WithField("exporter", cfg.Tracing.Exporter).WithField("service", service).Info("Tracing initiated")
+}
+
+// shutdownTracing exports the spans still queued and closes the exporter, once however often it's called.
+func shutdownTracing() {
+	tracingShutdown.Do(func() {
+		if err := tracing.Global().Shutdown(); err != nil {
+			log.WithError(err).Warn("Can't flush spans")
+		}
+	})
+}
+
+// kafkaHeaderCarrier carries trace context in kafka message headers.
+type kafkaHeaderCarrier struct {
+	msg *kafka.Message
+}
+
+func (c kafkaHeaderCarrier) Get(key string) string {
+	for _, h := range c.msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c kafkaHeaderCarrier) Set(key, value string) {
+	for i, h := range c.msg.Headers {
+		if h.Key == key {
+			c.msg.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	c.msg.Headers = append(c.msg.Headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+// traceHTTP starts a server span for every routed request, continuing the caller's trace if any.
+func traceHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tpl, err := cr.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+
+		ctx := tracing.Extract(r.Context(), tracing.HeaderCarrier(r.Header))
+		ctx, span := tracing.Start(ctx, r.Method+" "+route, tracing.KindServer)
+		defer span.End()
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.route", route)
+		if id := mux.Vars(r)["id"]; id != "" {
+			span.SetAttribute("inquiry.id", id)
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttribute("http.status_code", rec.status)
+		if rec.status >= 500 {
+			span.SetError(fmt.Errorf("%d %s", rec.status, http.StatusText(rec.status)))
+		}
+	})
+}
+
+// statusRecorder remembers the response status while still allowing SSE flushes and websocket upgrades.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	sr.status = code
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection's write deadline.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+func (sr *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := sr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer doesn't support hijacking")
+	}
+	sr.status = http.StatusSwitchingProtocols
+	return hj.Hijack()
+}
+
+// grpcMetadataCarrier carries trace context in incoming gRPC metadata.
+type grpcMetadataCarrier metadata.MD
+
+func (c grpcMetadataCarrier) Get(key string) string {
+	if vs := metadata.MD(c)[strings.ToLower(key)]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+func (c grpcMetadataCarrier) Set(key, value string) {
+	metadata.MD(c)[strings.ToLower(key)] = []string{value}
+}
+
+// grpcServerSpan starts the server span of a call, picking up the caller's trace context and correlation id.
+func grpcServerSpan(ctx context.Context, method string) (context.Context, *tracing.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	carrier := grpcMetadataCarrier(md)
+	ctx = tracing.Extract(ctx, carrier)
+
+	id := carrier.Get(CorrelationHeader)
+	if id == "" {
+		id = newCorrelationID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(CorrelationHeader, id))
+	ctx = withCorrelationID(ctx, id)
+
+	ctx, span := tracing.Start(ctx, method, tracing.KindServer)
+	span.SetAttribute("rpc.system", "grpc")
+	span.SetAttribute("rpc.method", method)
+	return ctx, span
+}
+
+func traceUnaryRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, span := grpcServerSpan(ctx, info.FullMethod)
+	defer span.End()
+
+	resp, err := handler(ctx, req)
+	span.SetError(err)
+	return resp, err
+}
+
+func traceStreamRPC(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, span := grpcServerSpan(ss.Context(), info.FullMethod)
+	defer span.End()
+
+	err := handler(srv, &tracedServerStream{ServerStream: ss, ctx: ctx})
+	span.SetError(err)
+	return err
+}
+
+// tracedServerStream hands the span carrying context to stream handlers.
+type tracedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tracedServerStream) Context() context.Context {
+	return s.ctx
+}
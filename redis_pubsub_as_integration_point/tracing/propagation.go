@@ -0,0 +1,56 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+)
+
+// Carrier is anything trace context can be written into and read back from.
+type Carrier interface {
+	Get(key string) string
+	Set(key, value string)
+}
+
+// HeaderCarrier carries trace context in HTTP headers or gRPC metadata like maps.
+type HeaderCarrier http.Header
+
+func (c HeaderCarrier) Get(key string) string { return http.Header(c).Get(key) }
+func (c HeaderCarrier) Set(key, value string) { http.Header(c).Set(key, value) }
+
+// MapCarrier carries trace context in a plain map.
+type MapCarrier map[string]string
+
+func (c MapCarrier) Get(key string) string { return c[key] }
+func (c MapCarrier) Set(key, value string) { c[key] = value }
+
+// Inject writes the current span's context into the carrier, doing nothing without a current span.
+func Inject(ctx context.Context, carrier Carrier) {
+	if sc, ok := parentFromContext(ctx); ok {
+		carrier.Set(TraceParentHeader, sc.TraceParent())
+	}
+}
+
+// Extract reads a remote parent from the carrier, returning the context unchanged when there's none.
+func Extract(ctx context.Context, carrier Carrier) context.Context {
+	return ExtractTraceParent(ctx, carrier.Get(TraceParentHeader))
+}
+
+// ExtractTraceParent is Extract for a traceparent value carried on its own, e.g. in a message payload.
+func ExtractTraceParent(ctx context.Context, traceParent string) context.Context {
+	if traceParent == "" {
+		return ctx
+	}
+	sc, err := ParseTraceParent(traceParent)
+	if err != nil {
+		return ctx
+	}
+	return ContextWithRemoteParent(ctx, sc)
+}
+
+// TraceParent returns the traceparent value of the current span, empty without one.
+func TraceParent(ctx context.Context) string {
+	if sc, ok := parentFromContext(ctx); ok {
+		return sc.TraceParent()
+	}
+	return ""
+}
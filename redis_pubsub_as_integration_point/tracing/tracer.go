@@ -0,0 +1,164 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// QueueSize is how many finished spans may wait for export before new ones are dropped
+	QueueSize = 2048
+	// BatchSize is the most spans handed to the exporter at once
+	BatchSize = 512
+	// FlushInterval is how often queued spans are exported
+	FlushInterval = time.Second
+)
+
+// Exporter ships finished spans somewhere.
+type Exporter interface {
+	Export(spans []SpanData) error
+	Shutdown() error
+}
+
+// ErrorHandler is told about spans which couldn't be exported.
+type ErrorHandler func(err error, dropped int)
+
+// Tracer starts spans and exports them in batches in the background.
+type Tracer struct {
+	exporter    Exporter
+	sampleRatio float64
+	onError     ErrorHandler
+
+	queue chan SpanData
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+var (
+	globalMu     sync.RWMutex
+	globalTracer = NewTracer(nil, 0, nil)
+)
+
+// NewTracer creates a tracer exporting the given ratio of new traces. Spans are still created and propagated
+// without an exporter, so that traces started upstream stay connected.
+func NewTracer(exporter Exporter, sampleRatio float64, onError ErrorHandler) *Tracer {
+	t := &Tracer{
+		exporter:    exporter,
+		sampleRatio: sampleRatio,
+		onError:     onError,
+		queue:       make(chan SpanData, QueueSize),
+		done:        make(chan struct{}),
+	}
+	if exporter != nil {
+		t.wg.Add(1)
+		go t.run()
+	}
+	return t
+}
+
+// SetGlobal replaces the tracer used by Start.
+func SetGlobal(t *Tracer) {
+	globalMu.Lock()
+	globalTracer = t
+	globalMu.Unlock()
+}
+
+// Global returns the tracer used by Start.
+func Global() *Tracer {
+	globalMu.RLock()
+	defer globalMu.RUnlock()
+	return globalTracer
+}
+
+// Start begins a span with the global tracer, see Tracer.Start.
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	return Global().Start(ctx, name, kind)
+}
+
+// Start begins a span, child of the span or remote parent found in the context if any.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	s := &Span{
+		tracer:     t,
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: map[string]interface{}{},
+	}
+
+	if parent, ok := parentFromContext(ctx); ok {
+		s.context = SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), Sampled: parent.Sampled}
+		s.parent = parent.SpanID
+	} else {
+		traceID := newTraceID()
+		s.context = SpanContext{TraceID: traceID, SpanID: newSpanID(), Sampled: sampled(traceID, t.sampleRatio)}
+	}
+	return ContextWithSpan(ctx, s), s
+}
+
+// Shutdown exports the queued spans and closes the exporter.
+func (t *Tracer) Shutdown() error {
+	if t.exporter == nil {
+		return nil
+	}
+	close(t.done)
+	t.wg.Wait()
+	return t.exporter.Shutdown()
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	if t.exporter == nil {
+		return
+	}
+	select {
+	case t.queue <- data:
+	default:
+		t.reportError(nil, 1)
+	}
+}
+
+func (t *Tracer) run() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.Export(batch); err != nil {
+			t.reportError(err, len(batch))
+		}
+		batch = make([]SpanData, 0, BatchSize)
+	}
+
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.done:
+			for {
+				select {
+				case data := <-t.queue:
+					batch = append(batch, data)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (t *Tracer) reportError(err error, dropped int) {
+	if t.onError != nil {
+		t.onError(err, dropped)
+	}
+}
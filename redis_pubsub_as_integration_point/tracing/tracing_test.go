@@ -0,0 +1,41 @@
+package tracing
+
+import "testing"
+
+func TestParseTraceParent(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceParent(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sc.Sampled || sc.TraceParent() != valid {
+		t.Errorf("parsed %q back as %q, sampled %v", valid, sc.TraceParent(), sc.Sampled)
+	}
+	if sc, err := ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future"); err != nil || sc.Sampled {
+		t.Errorf("later version with an extra field: %v, sampled %v", err, sc.Sampled)
+	}
+
+	for name, header := range map[string]string{
+		"empty":                "",
+		"too few fields":       "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"extra field in 00":    valid + "-extra",
+		"forbidden version":    "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"long version":         "000-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"oversized trace id":   "00-4bf92f3577b34da6a3ce929d0e0e473600-00f067aa0ba902b7-01",
+		"short trace id":       "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		"oversized span id":    "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b700-01",
+		"short span id":        "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902-01",
+		"non-hex trace id":     "00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01",
+		"non-hex span id":      "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902zz-01",
+		"zero trace id":        "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"zero span id":         "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"long flags":           "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0100",
+		"non-hex flags":        "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0z",
+		"only separators":      "---",
+		"oversized everywhere": "00-" + valid + valid + "-" + valid + "-01",
+	} {
+		if sc, err := ParseTraceParent(header); err == nil {
+			t.Errorf("%s: parsed %q as %+v", name, header, sc)
+		}
+	}
+}